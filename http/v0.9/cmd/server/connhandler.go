@@ -2,44 +2,153 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"strings"
+	"time"
 )
 
+// maxHeaderBytes limits the size of the request line and, for full
+// requests, the headers.
+const maxHeaderBytes = 64 * 1024
+
 func (s *Server) handleConnection(conn net.Conn) {
 	defer func() {
-		if err := conn.Close(); err != nil {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 			log.Printf("Failed to close connection: %v", err)
 		}
 	}()
 
-	reader := bufio.NewReader(conn)
-	line, _, err := reader.ReadLine() // GET /path/to/resource
+	if s.ReadHeaderTimeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(s.ReadHeaderTimeout)); err != nil {
+			return
+		}
+	}
+	limitReader := &io.LimitedReader{R: conn, N: maxHeaderBytes}
+	reader := textproto.NewReader(bufio.NewReader(limitReader))
+	line, err := reader.ReadLine() // GET /path/to/resource
 	if err != nil {
 		return
 	}
 
-	fields := strings.Fields(string(line))
-	if len(fields) < 2 {
+	// A Simple-Request is "GET" and the URI, a Full-Request adds the
+	// version, which is how HTTP/1.0 clients tell us they want headers
+	// and a status line (RFC 1945 4.1, 5).
+	fields := strings.Split(line, " ")
+	var r *http.Request
+	var w responseWriter
+	switch len(fields) {
+	case 2:
+		r, err = newSimpleRequest(fields[0], fields[1])
+		if err != nil {
+			// HTTP/0.9 has no way to report errors, all we can do is
+			// hang up.
+			slog.Warn("invalid simple request", "error", err, "remote_addr", conn.RemoteAddr().String())
+			return
+		}
+		w = &responseBodyWriter{conn: conn, headers: make(http.Header)}
+	case 3:
+		r, err = readFullRequest(reader, fields[0], fields[1], fields[2])
+		fw := &fullResponseWriter{conn: conn, headers: make(http.Header)}
+		if err != nil {
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) {
+				return
+			}
+			slog.Warn("invalid full request", "error", err, "remote_addr", conn.RemoteAddr().String())
+			fw.isHead = fields[0] == http.MethodHead
+			http.Error(fw, http.StatusText(reqErr.statusCode), reqErr.statusCode)
+			_ = fw.finish()
+			return
+		}
+		fw.isHead = r.Method == http.MethodHead
+		w = fw
+	default:
 		return
 	}
 
-	r := &http.Request{
-		Method: fields[0],
-		URL: &url.URL{
-			Scheme: "http",
-			Path:   fields[1],
-		},
+	if ok, wait := s.allowRequest(conn); !ok {
+		slog.Warn("rate limit exceeded", "remote_addr", conn.RemoteAddr().String())
+		// Simple requests can only be hung up on.
+		if fw, ok := w.(*fullResponseWriter); ok {
+			fw.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(fw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			_ = fw.finish()
+		}
+		return
+	}
+	// The body is limited by its Content-Length instead, and read without
+	// a deadline.
+	limitReader.N = math.MaxInt64
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	r.RemoteAddr = conn.RemoteAddr().String()
+	ctx, cancel := context.WithCancel(s.baseContext())
+	defer cancel()
+	start := time.Now()
+	s.Handler.ServeHTTP(w, r.WithContext(ctx))
+	if err := w.finish(); err != nil {
+		slog.Error("failed to finish response", "error", err, "remote_addr", r.RemoteAddr)
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("proto", r.Proto),
+	}
+	// HTTP/0.9 has no status, the body size is all there is to log.
+	if fw, ok := w.(*fullResponseWriter); ok {
+		attrs = append(attrs, slog.Int("status", fw.statusCode()))
+	}
+	slog.LogAttrs(ctx, slog.LevelInfo, "request", append(attrs,
+		slog.Int64("bytes", w.written()),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("remote_addr", r.RemoteAddr),
+	)...)
+}
+
+// newSimpleRequest builds the request of an HTTP/0.9 Simple-Request, which
+// can only be a GET without headers or body (RFC 1945 4.1).
+func newSimpleRequest(method, target string) (*http.Request, error) {
+	if method != http.MethodGet {
+		return nil, fmt.Errorf("method %q not allowed in a simple request", method)
+	}
+	u, err := parseRequestURI(target)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Request{
+		Method:     method,
+		URL:        u,
+		RequestURI: target,
 		Proto:      "HTTP/0.9",
 		ProtoMajor: 0,
 		ProtoMinor: 9,
-		RemoteAddr: conn.RemoteAddr().String(),
-	}
-
-	log.Printf("Method: %s, URL: %s, Proto: %s", r.Method, r.URL, r.Proto)
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+	}, nil
+}
 
-	s.Handler.ServeHTTP(newWriter(conn), r)
+// parseRequestURI parses the Request-URI, an absolute path or URI
+// (RFC 1945 5.1.2).
+func parseRequestURI(target string) (*url.URL, error) {
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	return u, nil
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// startServer serves handler on a random port and returns its address.
+func startServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	return startTestServer(t, &Server{Handler: handler})
+}
+
+// startTestServer serves s on a random port and returns its address.
+func startTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+	var lc net.ListenConfig
+	lis, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(func() { _ = s.Close() })
+	return lis.Addr().String()
+}
+
+// roundTrip sends raw and returns everything the server sent back before
+// closing the connection.
+func roundTrip(t *testing.T, addr, raw string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(resp)
+}
+
+// echoHandler sets a header, which HTTP/0.9 can't send, and echoes the
+// method, path and body.
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Proto", r.Proto)
+	body, _ := io.ReadAll(r.Body)
+	_, _ = io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+})
+
+func TestSimpleRequest(t *testing.T) {
+	addr := startServer(t, echoHandler)
+	for _, tt := range []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "get", raw: "GET /this/is/a/test\r\n", want: "GET /this/is/a/test "},
+		{name: "query", raw: "GET /search?q=go\r\n", want: "GET /search?q=go "},
+		{name: "bare lf", raw: "GET /\n", want: "GET / "},
+		// No way to report an error, the connection is closed.
+		{name: "post", raw: "POST /\r\n"},
+		{name: "head", raw: "HEAD /\r\n"},
+		{name: "invalid uri", raw: "GET relative\r\n"},
+		{name: "extra tokens", raw: "GET / HTTP/1.0 extra\r\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := roundTrip(t, addr, tt.raw); got != tt.want {
+				t.Fatalf("response = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFullRequest(t *testing.T) {
+	addr := startServer(t, echoHandler)
+	for _, tt := range []struct {
+		name       string
+		raw        string
+		wantStatus int
+		wantBody   string
+		wantHeader http.Header
+	}{
+		{
+			name:       "get",
+			raw:        "GET /a HTTP/1.0\r\nUser-Agent: test\r\n\r\n",
+			wantStatus: http.StatusOK,
+			wantBody:   "GET /a ",
+			wantHeader: http.Header{"X-Proto": {"HTTP/1.0"}, "Content-Type": {"text/plain; charset=utf-8"}},
+		},
+		{
+			name:       "http/1.1 is answered with 1.0",
+			raw:        "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			wantStatus: http.StatusOK,
+			wantBody:   "GET /a ",
+			wantHeader: http.Header{"X-Proto": {"HTTP/1.1"}},
+		},
+		{
+			name:       "post",
+			raw:        "POST /form HTTP/1.0\r\nContent-Length: 5\r\n\r\nhello",
+			wantStatus: http.StatusOK,
+			wantBody:   "POST /form hello",
+		},
+		{
+			name:       "head",
+			raw:        "HEAD /a HTTP/1.0\r\n\r\n",
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{"X-Proto": {"HTTP/1.0"}},
+		},
+		{
+			name:       "post without length",
+			raw:        "POST /form HTTP/1.0\r\n\r\nhello",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request\n",
+		},
+		{
+			name:       "unknown method",
+			raw:        "DELETE /a HTTP/1.0\r\n\r\n",
+			wantStatus: http.StatusNotImplemented,
+			wantBody:   "Not Implemented\n",
+		},
+		{
+			name:       "unsupported version",
+			raw:        "GET /a HTTP/2.0\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request\n",
+		},
+		{
+			name:       "malformed header",
+			raw:        "GET /a HTTP/1.0\r\nno colon\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := roundTrip(t, addr, tt.raw)
+			if !strings.HasPrefix(raw, "HTTP/1.0 ") {
+				t.Fatalf("response = %q, want an HTTP/1.0 status line", raw)
+			}
+			req, _ := http.NewRequest(strings.Fields(tt.raw)[0], "/", nil)
+			resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(raw)), req)
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if string(body) != tt.wantBody {
+				t.Fatalf("body = %q, want %q", body, tt.wantBody)
+			}
+			for k := range tt.wantHeader {
+				if got := resp.Header.Get(k); got != tt.wantHeader.Get(k) {
+					t.Fatalf("header %s = %q, want %q", k, got, tt.wantHeader.Get(k))
+				}
+			}
+		})
+	}
+}
+
+// TestFullRequestEmptyBody checks that a response without a body is
+// delimited by its Content-Length, not just by closing the connection.
+func TestFullRequestEmptyBody(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	raw := roundTrip(t, addr, "GET / HTTP/1.0\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(raw)), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusAccepted || resp.ContentLength != 0 {
+		t.Fatalf("status = %d, content length = %d, want 202, 0", resp.StatusCode, resp.ContentLength)
+	}
+}
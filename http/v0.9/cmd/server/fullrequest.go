@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// fullRequestMethods are the methods of RFC 1945 8, others are answered with
+// 501 Not Implemented.
+var fullRequestMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// requestError is a malformed or unsupported Full-Request, answered with
+// statusCode.
+type requestError struct {
+	statusCode int
+	err        error
+}
+
+func (e *requestError) Error() string {
+	return fmt.Sprintf("%d %s: %v", e.statusCode, http.StatusText(e.statusCode), e.err)
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// readFullRequest reads the headers of a Full-Request, whose request line
+// was split into method, target and proto, and sets up the body reader
+// (RFC 1945 5). HTTP/1.x clients are served as HTTP/1.0. Malformed or
+// unsupported requests are reported as a *requestError.
+func readFullRequest(reader *textproto.Reader, method, target, proto string) (*http.Request, error) {
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	if !ok || major != 1 {
+		return nil, &requestError{statusCode: http.StatusBadRequest, err: fmt.Errorf("unsupported version %q", proto)}
+	}
+	u, err := parseRequestURI(target)
+	if err != nil {
+		return nil, &requestError{statusCode: http.StatusBadRequest, err: err}
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			// The connection ended, or we hit the size limit, before
+			// the empty line that ends the headers.
+			return nil, &requestError{statusCode: http.StatusBadRequest, err: io.ErrUnexpectedEOF}
+		}
+		return nil, &requestError{statusCode: http.StatusBadRequest, err: err}
+	}
+
+	r := &http.Request{
+		Method:     method,
+		URL:        u,
+		RequestURI: target,
+		Proto:      proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
+		Header:     http.Header(header),
+		Host:       header.Get("Host"),
+		Body:       http.NoBody,
+	}
+	r.Header.Del("Host")
+	if u.Host != "" {
+		r.Host = u.Host
+	}
+
+	if !slices.Contains(fullRequestMethods, method) {
+		return nil, &requestError{statusCode: http.StatusNotImplemented, err: fmt.Errorf("unsupported method %q", method)}
+	}
+	if r.Header.Get("Transfer-Encoding") != "" {
+		// Transfer codings came with HTTP/1.1.
+		return nil, &requestError{statusCode: http.StatusNotImplemented, err: errors.New("transfer encodings are not supported")}
+	}
+
+	cl := r.Header.Get("Content-Length")
+	switch {
+	case cl != "":
+		r.ContentLength, err = strconv.ParseInt(cl, 10, 64)
+		if err != nil || r.ContentLength < 0 {
+			return nil, &requestError{statusCode: http.StatusBadRequest, err: fmt.Errorf("invalid content length %q", cl)}
+		}
+		if r.ContentLength > 0 {
+			r.Body = io.NopCloser(io.LimitReader(reader.R, r.ContentLength))
+		}
+	case method == http.MethodPost:
+		// A POST body can't be delimited without a length, the client
+		// would have to close the connection before reading the response
+		// (RFC 1945 7.2.2).
+		return nil, &requestError{statusCode: http.StatusBadRequest, err: errors.New("POST without Content-Length")}
+	}
+	return r, nil
+}
+
+// fullResponseWriter writes an HTTP/1.0 Full-Response: a status line, headers
+// and the body (RFC 1945 6). Unless the handler sets a Content-Length, the
+// body ends when the connection is closed.
+type fullResponseWriter struct {
+	conn        net.Conn
+	headers     http.Header
+	status      int
+	sentHeaders bool
+	// isHead suppresses the body of responses to HEAD requests.
+	isHead bool
+	bytes  int64
+}
+
+func (w *fullResponseWriter) Header() http.Header {
+	return w.headers
+}
+
+func (w *fullResponseWriter) WriteHeader(statusCode int) {
+	if w.sentHeaders || w.status != 0 {
+		slog.Warn(fmt.Sprintf("WriteHeader called twice, second time with: %d", statusCode))
+		return
+	}
+	w.status = statusCode
+}
+
+func (w *fullResponseWriter) Write(b []byte) (int, error) {
+	if !w.sentHeaders {
+		if _, ok := w.headers["Content-Type"]; !ok && len(b) > 0 {
+			w.headers.Set("Content-Type", http.DetectContentType(b))
+		}
+		if err := w.sendHeaders(); err != nil {
+			return 0, err
+		}
+	}
+	if w.isHead {
+		return len(b), nil
+	}
+	n, err := w.conn.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *fullResponseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// sendHeaders writes the status line and headers.
+func (w *fullResponseWriter) sendHeaders() error {
+	w.sentHeaders = true
+	if _, ok := w.headers["Date"]; !ok {
+		w.headers.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.0 %d %s\r\n", w.statusCode(), http.StatusText(w.statusCode()))
+	if err := w.headers.Write(&buf); err != nil {
+		return err
+	}
+	buf.WriteString("\r\n")
+	_, err := w.conn.Write(buf.Bytes())
+	return err
+}
+
+// finish sends the headers if the handler didn't write a body.
+func (w *fullResponseWriter) finish() error {
+	if w.sentHeaders {
+		return nil
+	}
+	if !w.isHead && w.headers.Get("Content-Length") == "" {
+		w.headers.Set("Content-Length", "0")
+	}
+	return w.sendHeaders()
+}
+
+func (w *fullResponseWriter) written() int64 {
+	return w.bytes
+}
@@ -1,22 +1,45 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"time"
 )
 
 func main() {
 	addr := "127.0.0.1:9000"
-	s := Server{
-		Addr: addr,
+	s := &Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 10 * time.Second,
+		MaxConns:          100,
+		RateLimit:         10,
+		RateBurst:         20,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			if _, err := w.Write([]byte("Hello World!")); err != nil {
 				log.Printf("Failed to write response: %v", err)
 			}
 		}),
 	}
+
+	// Drain in-flight requests on Ctrl+C instead of dropping them.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop() // a second Ctrl+C kills the process
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down: %v", err)
+		}
+	}()
+
 	log.Printf("Listening on %s", addr)
-	if err := s.ServeAndListen(); err != nil {
+	if err := s.ServeAndListen(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The rate limiter is copied to the v1.0 server in http/v1.0/cmd/server,
+// which is a separate module. Apply fixes to both copies.
+
+// rateLimiterSweepInterval is how often buckets that filled up again are
+// dropped, so clients that went away don't use memory forever.
+const rateLimiterSweepInterval = time.Minute
+
+// rateLimiter is a token bucket per client address. Each bucket holds up to
+// burst tokens and refills at rate tokens per second, every request takes
+// one.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the bucket of key at now. If the bucket is empty,
+// it returns false and how long until the next token is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= rateLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.tokensAt(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// tokensAt returns the tokens in b at now.
+func (l *rateLimiter) tokensAt(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed <= 0 {
+		return b.tokens
+	}
+	return min(l.burst, b.tokens+elapsed*l.rate)
+}
+
+// sweep drops full buckets, they are the same as no bucket at all.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if l.tokensAt(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// remoteIP returns the IP address of the peer of conn, which clients can't
+// change per connection like the port.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// retryAfter formats d as the Retry-After header value in whole seconds,
+// rounded up (RFC 9110 10.2.3).
+func retryAfter(d time.Duration) string {
+	return strconv.FormatInt(max(1, int64(math.Ceil(d.Seconds()))), 10)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	now := time.Unix(1000, 0)
+
+	for _, tt := range []struct {
+		key      string
+		at       time.Duration
+		wantOK   bool
+		wantWait time.Duration
+	}{
+		{key: "a", wantOK: true},
+		{key: "a", wantOK: true},
+		{key: "a", wantOK: false, wantWait: time.Second},
+		{key: "b", wantOK: true},
+		{key: "a", at: 400 * time.Millisecond, wantOK: false, wantWait: 600 * time.Millisecond},
+		{key: "a", at: time.Second, wantOK: true},
+		{key: "a", at: time.Hour, wantOK: true},
+		{key: "a", at: time.Hour, wantOK: true},
+		{key: "a", at: time.Hour, wantOK: false, wantWait: time.Second},
+	} {
+		ok, wait := l.allow(tt.key, now.Add(tt.at))
+		if ok != tt.wantOK || wait != tt.wantWait {
+			t.Fatalf("allow(%q) at %v = %v, %v, want %v, %v", tt.key, tt.at, ok, wait, tt.wantOK, tt.wantWait)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := &Server{Handler: echoHandler, RateLimit: 0.01, RateBurst: 1}
+	addr := startTestServer(t, s)
+
+	if got := roundTrip(t, addr, "GET /\r\n"); got != "GET / " {
+		t.Fatalf("response = %q, want %q", got, "GET / ")
+	}
+	// Over the limit, a simple request is hung up on.
+	if got := roundTrip(t, addr, "GET /\r\n"); got != "" {
+		t.Fatalf("response = %q, want none", got)
+	}
+	// A full request gets told when to come back.
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(roundTrip(t, addr, "GET / HTTP/1.0\r\n\r\n"))), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "100" {
+		t.Fatalf("Retry-After = %q, want %q", got, "100")
+	}
+}
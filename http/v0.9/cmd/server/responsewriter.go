@@ -5,23 +5,44 @@ import (
 	"net/http"
 )
 
-type responseBodyWriter struct {
-	conn net.Conn
+// responseWriter is implemented by the writers of both kinds of responses.
+type responseWriter interface {
+	http.ResponseWriter
+	// finish completes the response once the handler returned.
+	finish() error
+	// written returns the size of the body, for the access log.
+	written() int64
 }
 
-func newWriter(c net.Conn) http.ResponseWriter {
-	return &responseBodyWriter{conn: c}
+// responseBodyWriter writes an HTTP/0.9 response, which is just the body.
+type responseBodyWriter struct {
+	conn net.Conn
+	// headers may be set by handlers written for later versions, they are
+	// never sent.
+	headers http.Header
+	// bytes is the size of the response, for the access log.
+	bytes int64
 }
 
 func (r *responseBodyWriter) Header() http.Header {
-	// unsupported with HTTP/0.9
-	return nil
+	return r.headers
 }
 
 func (r *responseBodyWriter) Write(b []byte) (int, error) {
-	return r.conn.Write(b)
+	n, err := r.conn.Write(b)
+	r.bytes += int64(n)
+	return n, err
 }
 
 func (r *responseBodyWriter) WriteHeader(_ int) {
 	// unsupported with HTTP/0.9
 }
+
+func (r *responseBodyWriter) finish() error {
+	// The response ends when the connection is closed.
+	return nil
+}
+
+func (r *responseBodyWriter) written() int64 {
+	return r.bytes
+}
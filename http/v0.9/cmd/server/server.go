@@ -5,24 +5,79 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// shutdownPollInterval is how often Shutdown checks whether all connections
+// are done.
+const shutdownPollInterval = 10 * time.Millisecond
+
 type Server struct {
 	Addr    string
 	Handler http.Handler
+
+	// ReadHeaderTimeout is how long a client may take to send the request
+	// line and, for full requests, the headers, before the connection is
+	// closed. If zero, there is no timeout.
+	ReadHeaderTimeout time.Duration
+	// MaxConns limits the number of connections served at once. Once it is
+	// reached, Serve stops accepting until a connection is done, leaving
+	// clients to wait in the listen backlog. Set ReadHeaderTimeout too, or
+	// clients that never send a request hold their slots forever. If zero,
+	// connections are not limited.
+	MaxConns int
+	// RateLimit is the number of requests per second allowed per client IP
+	// address, enforced with a token bucket holding RateBurst tokens. Full
+	// requests over the limit are answered with 503 Service Unavailable and
+	// a Retry-After header, simple requests can only be hung up on. If
+	// zero, requests are not limited.
+	RateLimit float64
+	// RateBurst is how many requests a client may send at once before
+	// RateLimit applies. If zero, RateLimit rounded up is used.
+	RateBurst int
+
+	inShutdown atomic.Bool
+
+	mu       sync.Mutex
+	listener net.Listener
+	// HTTP/0.9 has no persistent connections, so every tracked connection
+	// is serving exactly one request.
+	conns map[net.Conn]struct{}
+	// connSlots holds a value per connection being served if MaxConns is
+	// set.
+	connSlots chan struct{}
+	limiter   *rateLimiter
+	// baseCtx is the parent of every request context, canceled by Shutdown
+	// and Close.
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
 }
 
 func (s *Server) ServeAndListen() error {
 	if s.Handler == nil {
 		panic("http server started without a handler")
 	}
+	if s.inShutdown.Load() {
+		return http.ErrServerClosed
+	}
 	var lc net.ListenConfig
 	l, err := lc.Listen(context.Background(), "tcp", s.Addr)
 	if err != nil {
 		return err
 	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Shutdown or Close is called, after
+// which it returns http.ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l) {
+		return http.ErrServerClosed
+	}
 	defer func() {
-		if err := l.Close(); err != nil {
+		if err := l.Close(); err != nil && !s.inShutdown.Load() {
 			log.Printf("Failed to close listener: %v", err)
 		}
 	}()
@@ -30,10 +85,169 @@ func (s *Server) ServeAndListen() error {
 	for {
 		conn, err := l.Accept()
 		if err != nil {
+			if s.inShutdown.Load() {
+				return http.ErrServerClosed
+			}
 			log.Printf("Failed to accept connection: %v", err)
 			return err
 		}
+		// We don't accept more connections while this one waits.
+		if !s.acquireConnSlot() {
+			_ = conn.Close()
+			return http.ErrServerClosed
+		}
+		if !s.trackConn(conn) {
+			s.releaseConnSlot()
+			_ = conn.Close()
+			continue
+		}
+
+		go func() {
+			defer s.releaseConnSlot()
+			defer s.untrackConn(conn)
+			s.handleConnection(conn)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections, cancels the context of in-flight
+// requests and waits for them to finish. If ctx expires first, the remaining
+// connections are closed and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	err := s.closeListenerLocked()
+	s.cancelBaseLocked()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.NumConns() == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			if err := s.Close(); err != nil {
+				log.Printf("Failed to close server: %v", err)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes the listener and all connections.
+func (s *Server) Close() error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.closeListenerLocked()
+	s.cancelBaseLocked()
+	for conn := range s.conns {
+		_ = conn.Close()
+		delete(s.conns, conn)
+	}
+	return err
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown.Load() {
+		_ = l.Close()
+		return false
+	}
+	s.listener = l
+	if s.MaxConns > 0 && s.connSlots == nil {
+		s.connSlots = make(chan struct{}, s.MaxConns)
+	}
+	if s.RateLimit > 0 && s.limiter == nil {
+		s.limiter = newRateLimiter(s.RateLimit, s.RateBurst)
+	}
+	return true
+}
+
+// acquireConnSlot waits until another connection may be served. It returns
+// false if the server shuts down first.
+func (s *Server) acquireConnSlot() bool {
+	if s.connSlots == nil {
+		return true
+	}
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	case <-s.baseContext().Done():
+		return false
+	}
+}
+
+func (s *Server) releaseConnSlot() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+// allowRequest reports whether the rate limit lets the client on conn send
+// another request, and otherwise how long it has to wait.
+func (s *Server) allowRequest(conn net.Conn) (bool, time.Duration) {
+	if s.limiter == nil {
+		return true, 0
+	}
+	return s.limiter.allow(remoteIP(conn), time.Now())
+}
+
+func (s *Server) closeListenerLocked() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	s.listener = nil
+	return err
+}
+
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown.Load() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// NumConns returns the number of open connections.
+func (s *Server) NumConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
 
-		go s.handleConnection(conn)
+func (s *Server) baseContext() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baseContextLocked()
+}
+
+func (s *Server) baseContextLocked() context.Context {
+	if s.baseCtx == nil {
+		s.baseCtx, s.cancelBase = context.WithCancel(context.Background())
 	}
+	return s.baseCtx
+}
+
+func (s *Server) cancelBaseLocked() {
+	s.baseContextLocked()
+	s.cancelBase()
 }
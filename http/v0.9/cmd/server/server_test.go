@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "done")
+	})}
+
+	var lc net.ListenConfig
+	lis, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	serveErr := make(chan error)
+	go func() { serveErr <- s.Serve(lis) }()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := io.WriteString(conn, "GET /\r\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	<-started
+
+	shutdownErr := make(chan error)
+	go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("Serve = %v, want %v", err, http.ErrServerClosed)
+	}
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned before the request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	body, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(body) != "done" {
+		t.Fatalf("body = %q, want %q", body, "done")
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s := &Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+			_, _ = io.WriteString(w, r.URL.Path)
+		}),
+		MaxConns: 1,
+	}
+	addr := startTestServer(t, s)
+
+	dial := func(path string) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		if _, err := io.WriteString(conn, "GET "+path+"\r\n"); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return conn
+	}
+	first := dial("/first")
+	<-started
+	second := dial("/second")
+
+	// The second connection waits until the first is done.
+	select {
+	case <-started:
+		t.Fatalf("second request started while the first was served")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := s.NumConns(); got != 1 {
+		t.Fatalf("connections = %d, want 1", got)
+	}
+
+	close(release)
+	for conn, want := range map[net.Conn]string{first: "/first", second: "/second"} {
+		body, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(body) != want {
+			t.Fatalf("body = %q, want %q", body, want)
+		}
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	s := &Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.URL.Path)
+		}),
+		ReadHeaderTimeout: 50 * time.Millisecond,
+		MaxConns:          1,
+	}
+	addr := startTestServer(t, s)
+
+	// A client that never sends a request is hung up on, freeing its slot.
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = idle.Close() }()
+	_ = idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := idle.Read(make([]byte, 1)); n != 0 || !errors.Is(err, io.EOF) {
+		t.Fatalf("idle read = %d, %v, want 0, EOF", n, err)
+	}
+
+	if got := roundTrip(t, addr, "GET /next\r\n"); got != "/next" {
+		t.Fatalf("body = %q, want %q", got, "/next")
+	}
+}
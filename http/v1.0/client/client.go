@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultMaxRedirects is how many redirects a Client without CheckRedirect
+// follows.
+const defaultMaxRedirects = 10
+
+// maxDrainBytes is how much of a redirect response body we read to reuse
+// the connection, larger bodies close it instead.
+const maxDrainBytes = 4 << 10
+
+// Client sends requests with a RoundTripper and follows redirects.
+// Timeouts are set with the request context.
+type Client struct {
+	// Transport sends the requests. If nil, DefaultTransport is used.
+	Transport http.RoundTripper
+	// CheckRedirect is called before following a redirect, with the next
+	// request and the requests made so far, oldest first. If it returns
+	// http.ErrUseLastResponse, the redirect response is returned with its
+	// body unread. Other errors are returned from Do. If nil, up to 10
+	// redirects are followed.
+	CheckRedirect func(req *http.Request, via []*http.Request) error
+}
+
+// Get issues a GET request to rawURL.
+func (c *Client) Get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req and follows redirects (RFC 9110 15.4). 301, 302 and 303
+// turn any method but HEAD into a GET without a body, like net/http and
+// browsers do, and 307 and 308 repeat the request as is, which requires
+// req.GetBody if there is a body. Errors are returned as *url.Error.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	var via []*http.Request
+	for {
+		resp, err := c.transport().RoundTrip(req)
+		if err != nil {
+			return nil, &url.Error{Op: urlErrorOp(req.Method), URL: req.URL.String(), Err: err}
+		}
+
+		next, err := redirectRequest(req, resp)
+		if err != nil {
+			_ = resp.Body.Close()
+			return nil, &url.Error{Op: urlErrorOp(req.Method), URL: req.URL.String(), Err: err}
+		}
+		if next == nil {
+			return resp, nil
+		}
+
+		via = append(via, req)
+		if err := c.checkRedirect(next, via); err != nil {
+			if errors.Is(err, http.ErrUseLastResponse) {
+				return resp, nil
+			}
+			_ = resp.Body.Close()
+			return nil, &url.Error{Op: urlErrorOp(req.Method), URL: next.URL.String(), Err: err}
+		}
+		// Read a small body to the end, so the connection can be reused.
+		_, _ = io.CopyN(io.Discard, resp.Body, maxDrainBytes)
+		_ = resp.Body.Close()
+		req = next
+	}
+}
+
+// redirectRequest returns the request that follows the redirect in resp, or
+// nil if resp is not a redirect we can follow.
+func redirectRequest(req *http.Request, resp *http.Response) (*http.Request, error) {
+	method := req.Method
+	keepBody := false
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+		if method != http.MethodHead {
+			method = http.MethodGet
+		}
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		keepBody = true
+	default:
+		return nil, nil
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		// Without a target there is nothing to follow, the caller gets
+		// the response.
+		return nil, nil
+	}
+	u, err := req.URL.Parse(loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Location %q: %w", loc, err)
+	}
+
+	var body io.ReadCloser
+	if keepBody && hasBody(req) {
+		if req.GetBody == nil {
+			// The body is gone, the caller can decide what to do.
+			return nil, nil
+		}
+		if body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	next, err := http.NewRequestWithContext(req.Context(), method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	next.Header = req.Header.Clone()
+	if next.Header == nil {
+		next.Header = make(http.Header)
+	}
+	if keepBody {
+		next.ContentLength = req.ContentLength
+		next.GetBody = req.GetBody
+	} else {
+		next.Header.Del("Content-Type")
+		next.Header.Del("Content-Length")
+	}
+	if next.URL.Host != req.URL.Host {
+		// Credentials are meant for the original host only.
+		for _, k := range []string{"Authorization", "Proxy-Authorization", "Www-Authenticate", "Cookie", "Cookie2"} {
+			next.Header.Del(k)
+		}
+	}
+	return next, nil
+}
+
+func (c *Client) transport() http.RoundTripper {
+	if c.Transport != nil {
+		return c.Transport
+	}
+	return DefaultTransport
+}
+
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if c.CheckRedirect != nil {
+		return c.CheckRedirect(req, via)
+	}
+	if len(via) >= defaultMaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", defaultMaxRedirects)
+	}
+	return nil
+}
+
+// urlErrorOp returns the Op of a *url.Error for method, like net/http: "Get",
+// "Post" and so on.
+func urlErrorOp(method string) string {
+	if method == "" {
+		return "Get"
+	}
+	if len(method) > 1 {
+		return method[:1] + strings.ToLower(method[1:])
+	}
+	return method
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestClientRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/found", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/echo", http.StatusFound)
+	})
+	mux.HandleFunc("/see-other", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/echo", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/temporary", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/echo", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/relative/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "../echo?from=relative", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.RawQuery+" "+string(body))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	client := &Client{Transport: &Transport{}}
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   string
+	}{
+		{name: "302 post becomes get", method: http.MethodPost, path: "/found", body: "hello", want: "GET  "},
+		{name: "302 put becomes get", method: http.MethodPut, path: "/found", body: "hello", want: "GET  "},
+		{name: "301 patch becomes get", method: http.MethodPatch, path: "/relative/x", body: "hello", want: "GET from=relative "},
+		{name: "303", method: http.MethodPut, path: "/see-other", body: "hello", want: "GET  "},
+		{name: "307 keeps body", method: http.MethodPost, path: "/temporary", body: "hello", want: "POST  hello"},
+		{name: "relative location", method: http.MethodGet, path: "/relative/x", want: "GET from=relative "},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, body)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("do: %v", err)
+			}
+			if body := readBody(t, resp); body != tt.want {
+				t.Fatalf("body = %q, want %q", body, tt.want)
+			}
+		})
+	}
+
+	t.Run("too many", func(t *testing.T) {
+		_, err := client.Get(context.Background(), srv.URL+"/loop")
+		var urlErr *url.Error
+		if !errors.As(err, &urlErr) || !strings.Contains(err.Error(), "stopped after 10 redirects") {
+			t.Fatalf("error = %v, want redirect limit", err)
+		}
+	})
+
+	t.Run("use last response", func(t *testing.T) {
+		client := &Client{
+			Transport: client.Transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		resp, err := client.Get(context.Background(), srv.URL+"/found")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		readBody(t, resp)
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/echo" {
+			t.Fatalf("status = %d, location = %q, want 302 to /echo", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+}
+
+func TestClientRedirectDropsCredentials(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(other.Close)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/same" {
+			_, _ = io.WriteString(w, r.Header.Get("Authorization"))
+			return
+		}
+		target := other.URL
+		if r.URL.Query().Has("same") {
+			target = "/same"
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{query: "", want: ""},
+		{query: "?same", want: "Bearer secret"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/"+tt.query, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := (&Client{}).Do(req)
+		if err != nil {
+			t.Fatalf("do: %v", err)
+		}
+		if body := readBody(t, resp); body != tt.want {
+			t.Fatalf("%q: Authorization = %q, want %q", tt.query, body, tt.want)
+		}
+	}
+}
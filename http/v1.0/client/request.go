@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/chunked"
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+// reqWriteExcludeHeader are the headers writeRequest sets itself.
+var reqWriteExcludeHeader = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Trailer":           true,
+}
+
+// writeRequest serializes req to w (RFC 9112 3). Bodies of unknown length
+// are sent in chunks. If closeConn is set, the server is asked to close the
+// connection after responding. The request body is closed.
+func writeRequest(w io.Writer, req *http.Request, closeConn bool) (err error) {
+	if hasBody(req) {
+		defer func() {
+			if closeErr := req.Body.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !http1.ValidToken(method) {
+		return fmt.Errorf("client: invalid method %q", method)
+	}
+	target := req.URL.RequestURI()
+	if method == http.MethodConnect {
+		target = req.URL.Host
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	// A line break in the host would start another header.
+	if strings.ContainsAny(host, " \r\n") {
+		return fmt.Errorf("client: invalid host %q", host)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %s HTTP/1.1\r\nHost: %s\r\n", method, target, host)
+
+	length := outgoingLength(req)
+	switch {
+	case length > 0:
+		fmt.Fprintf(bw, "Content-Length: %d\r\n", length)
+	case length < 0:
+		fmt.Fprint(bw, "Transfer-Encoding: chunked\r\n")
+	case method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch:
+		// Servers may insist on a length for methods that usually have
+		// a body (RFC 9110 8.6).
+		fmt.Fprint(bw, "Content-Length: 0\r\n")
+	}
+	if closeConn && !http1.HasToken(req.Header, "Connection", "close") {
+		fmt.Fprint(bw, "Connection: close\r\n")
+	}
+	if err := req.Header.WriteSubset(bw, reqWriteExcludeHeader); err != nil {
+		return err
+	}
+	fmt.Fprint(bw, "\r\n")
+
+	if length != 0 {
+		if err := writeBody(bw, req.Body, length); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeBody copies the request body to w, in chunks if length is negative.
+// A body that doesn't match the announced length is an error, the server
+// would read it differently.
+func writeBody(w io.Writer, body io.Reader, length int64) error {
+	if length < 0 {
+		cw := chunked.NewWriter(w)
+		if _, err := io.Copy(cw, body); err != nil {
+			return err
+		}
+		return cw.Close()
+	}
+
+	n, err := io.Copy(w, io.LimitReader(body, length))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("client: request body is %d bytes, Content-Length is %d", n, length)
+	}
+	// Anything left over would be read as the next request.
+	var extra [1]byte
+	if n, _ := body.Read(extra[:]); n > 0 {
+		return errors.New("client: request body longer than Content-Length")
+	}
+	return nil
+}
+
+// outgoingLength returns the length of the request body, -1 if unknown.
+// Like net/http, a ContentLength of zero with a non-nil body means unknown.
+func outgoingLength(req *http.Request) int64 {
+	if !hasBody(req) {
+		return 0
+	}
+	if req.ContentLength != 0 {
+		return req.ContentLength
+	}
+	return -1
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/chunked"
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+// readResponse reads the response to req from the connection, skipping
+// informational responses, and sets up the body reader (RFC 9112 6.3).
+// Bodies that end when the connection closes set resp.Close.
+func (pc *persistConn) readResponse(req *http.Request) (*http.Response, error) {
+	for {
+		pc.lr.N = pc.t.maxResponseHeaderBytes()
+		if _, err := pc.br.Peek(1); err != nil {
+			if pc.reused && errors.Is(err, io.EOF) {
+				return nil, errServerClosedIdle
+			}
+			return nil, err
+		}
+		resp, err := readResponseHeader(textproto.NewReader(pc.br))
+		if err != nil {
+			if pc.lr.N <= 0 {
+				return nil, fmt.Errorf("client: response headers exceed %d bytes", pc.t.maxResponseHeaderBytes())
+			}
+			return nil, err
+		}
+		// 1xx responses precede the final one, e.g. 103 Early Hints. 101
+		// Switching Protocols is final.
+		if resp.StatusCode >= 100 && resp.StatusCode <= 199 && resp.StatusCode != http.StatusSwitchingProtocols {
+			continue
+		}
+		pc.lr.N = math.MaxInt64
+		resp.Request = req
+		if tlsConn, ok := pc.conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			resp.TLS = &state
+		}
+		if err := setBody(resp, pc); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// readResponseHeader reads the status line and headers.
+func readResponseHeader(r *textproto.Reader) (*http.Response, error) {
+	// HTTP/1.1 200 OK
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("read status line: %w", unexpectedEOF(err))
+	}
+	proto, status, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, fmt.Errorf("malformed status line %q", line)
+	}
+	resp := &http.Response{Proto: proto}
+	resp.ProtoMajor, resp.ProtoMinor, ok = http.ParseHTTPVersion(proto)
+	if !ok || resp.ProtoMajor != 1 {
+		return nil, fmt.Errorf("unsupported proto %q", proto)
+	}
+	// The reason phrase is optional, and may be missing the space too.
+	code, _, _ := strings.Cut(status, " ")
+	if len(code) != 3 || strings.TrimLeft(code, "0123456789") != "" {
+		return nil, fmt.Errorf("malformed status code %q", code)
+	}
+	resp.StatusCode, _ = strconv.Atoi(code)
+	if resp.StatusCode < 100 {
+		return nil, fmt.Errorf("malformed status code %q", code)
+	}
+	resp.Status = strings.TrimSpace(status)
+
+	mimeHeader, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("read headers: %w", unexpectedEOF(err))
+	}
+	resp.Header = http.Header(mimeHeader)
+	return resp, nil
+}
+
+// setBody sets up resp.Body to read from pc, and decides whether the
+// connection can carry another request.
+func setBody(resp *http.Response, pc *persistConn) error {
+	h := resp.Header
+	resp.Close = http1.HasToken(h, "Connection", "close") ||
+		(!resp.ProtoAtLeast(1, 1) && !http1.HasToken(h, "Connection", "keep-alive"))
+
+	contentLength, err := http1.ParseContentLength(h.Values("Content-Length"))
+	if err != nil {
+		return err
+	}
+	resp.ContentLength = contentLength
+	if cl := h["Content-Length"]; len(cl) > 1 {
+		h["Content-Length"] = cl[:1]
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusSwitchingProtocols:
+		// The connection speaks another protocol now, we can't reuse it.
+		resp.Close = true
+		resp.ContentLength = -1
+		resp.Body = http.NoBody
+		return nil
+	case resp.Request.Method == http.MethodHead, !http1.BodyAllowed(resp.StatusCode):
+		// The headers describe the body a GET would get, there is none.
+		resp.Body = http.NoBody
+		return nil
+	case resp.Request.Method == http.MethodConnect && resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		// A tunnel, everything after the headers belongs to it.
+		resp.Close = true
+		resp.ContentLength = -1
+		resp.Body = io.NopCloser(pc.br)
+		return nil
+	}
+
+	if te := h.Values("Transfer-Encoding"); len(te) > 0 {
+		resp.ContentLength = -1
+		h.Del("Content-Length")
+		if len(te) == 1 && strings.EqualFold(strings.TrimSpace(te[0]), "chunked") {
+			h.Del("Transfer-Encoding")
+			resp.TransferEncoding = []string{"chunked"}
+			resp.Trailer = http1.ParseTrailerKeys(h)
+			resp.Body = io.NopCloser(chunked.NewReader(pc.br, resp.Trailer))
+			return nil
+		}
+		// Other codings can only be delimited by closing the connection
+		// (RFC 9112 6.3).
+		resp.Close = true
+		resp.Body = io.NopCloser(pc.br)
+		return nil
+	}
+
+	switch {
+	case contentLength == 0:
+		resp.Body = http.NoBody
+	case contentLength > 0:
+		resp.Body = io.NopCloser(&lengthReader{r: io.LimitReader(pc.br, contentLength), n: contentLength})
+	default:
+		// The body ends when the server closes the connection.
+		resp.Close = true
+		resp.Body = io.NopCloser(pc.br)
+	}
+	return nil
+}
+
+// lengthReader reads a body of n bytes, and fails with io.ErrUnexpectedEOF
+// if the connection ends early.
+type lengthReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if errors.Is(err, io.EOF) && l.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,420 @@
+// Package client implements an HTTP/1.1 client with a keep-alive connection
+// pool. Transport is an http.RoundTripper, so it can back a standard
+// http.Client, and Client adds redirects on top of any RoundTripper.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+const (
+	// defaultMaxIdleConnsPerHost is used if Transport.MaxIdleConnsPerHost is
+	// not set.
+	defaultMaxIdleConnsPerHost = 2
+	// defaultIdleConnTimeout is used if Transport.IdleConnTimeout is not set.
+	defaultIdleConnTimeout = 90 * time.Second
+	// defaultMaxResponseHeaderBytes is used if
+	// Transport.MaxResponseHeaderBytes is not set.
+	defaultMaxResponseHeaderBytes = 1 * 1024 * 1024
+)
+
+// DefaultTransport is the Transport used by a Client without one.
+var DefaultTransport = &Transport{}
+
+// errServerClosedIdle is returned when a connection taken from the pool
+// turns out to be closed by the server before it sent anything. The server
+// may still have processed the request, so only idempotent ones are retried
+// on a new connection.
+var errServerClosedIdle = errors.New("client: server closed idle connection")
+
+// Transport sends requests over HTTP/1.1 and keeps connections open for
+// reuse. The zero value is ready to use. A Transport is safe for concurrent
+// use and should be reused rather than created per request.
+type Transport struct {
+	// DialContext opens the TCP connection. If nil, net.Dialer is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// TLSClientConfig is used for https requests. If nil, the default
+	// configuration is used.
+	TLSClientConfig *tls.Config
+	// DisableKeepAlives, if true, sends every request on a new connection
+	// and asks the server to close it afterwards.
+	DisableKeepAlives bool
+	// MaxIdleConnsPerHost limits how many idle connections are kept per
+	// host. If zero, 2 is used.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept. If zero, 90
+	// seconds is used.
+	IdleConnTimeout time.Duration
+	// MaxResponseHeaderBytes limits the size of the status line and headers
+	// of a response. If zero, 1MB is used.
+	MaxResponseHeaderBytes int64
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+// persistConn is a connection that may carry several requests, one at a
+// time.
+type persistConn struct {
+	t    *Transport
+	key  string
+	conn net.Conn
+	// lr limits how much of the response headers we read, br reads from it
+	// and is kept across responses.
+	lr *io.LimitedReader
+	br *bufio.Reader
+	// reused is set if the connection came from the pool.
+	reused bool
+	idleAt time.Time
+}
+
+// RoundTrip sends req and returns the response. The response body must be
+// read to EOF and closed for the connection to be reused. Canceling the
+// request context aborts the request, including reading the body.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.roundTrip(req)
+	if err != nil && req.Body != nil {
+		// The body must be closed even on errors.
+		_ = req.Body.Close()
+	}
+	return resp, err
+}
+
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL == nil {
+		return nil, errors.New("client: nil request URL")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("client: unsupported scheme %q", req.URL.Scheme)
+	}
+	if req.URL.Host == "" {
+		return nil, errors.New("client: no host in request URL")
+	}
+
+	ctx := req.Context()
+	for {
+		pc, err := t.getConn(ctx, req.URL.Scheme, req.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := pc.roundTrip(req)
+		if err == nil {
+			return resp, nil
+		}
+		if !errors.Is(err, errServerClosedIdle) || !rewindable(req) {
+			return nil, err
+		}
+		// The server closed the connection while it sat in the pool, try
+		// again on a fresh one.
+		if req, err = rewind(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// CloseIdleConnections closes the connections in the pool. Connections in
+// use are not interrupted. http.Client calls this from its method of the
+// same name.
+func (t *Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	idle := t.idle
+	t.idle = nil
+	t.mu.Unlock()
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.close()
+		}
+	}
+}
+
+// getConn returns an idle connection to the host, or dials a new one.
+func (t *Transport) getConn(ctx context.Context, scheme, host string) (*persistConn, error) {
+	addr := hostPort(scheme, host)
+	key := scheme + "://" + addr
+	if pc := t.getIdle(key); pc != nil {
+		return pc, nil
+	}
+
+	dial := t.DialContext
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == "https" {
+		cfg := &tls.Config{}
+		if t.TLSClientConfig != nil {
+			cfg = t.TLSClientConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	lr := &io.LimitedReader{R: conn}
+	return &persistConn{t: t, key: key, conn: conn, lr: lr, br: bufio.NewReader(lr)}, nil
+}
+
+// getIdle takes the most recently used idle connection for key out of the
+// pool. Connections that were idle for too long are closed.
+func (t *Transport) getIdle(key string) *persistConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conns := t.idle[key]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(pc.idleAt) > t.idleConnTimeout() {
+			pc.close()
+			continue
+		}
+		t.idle[key] = conns
+		pc.reused = true
+		return pc
+	}
+	delete(t.idle, key)
+	return nil
+}
+
+// putIdle returns pc to the pool, or closes it if the pool is full.
+func (t *Transport) putIdle(pc *persistConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.DisableKeepAlives || len(t.idle[pc.key]) >= t.maxIdleConnsPerHost() {
+		pc.close()
+		return
+	}
+	if t.idle == nil {
+		t.idle = make(map[string][]*persistConn)
+	}
+	pc.idleAt = time.Now()
+	t.idle[pc.key] = append(t.idle[pc.key], pc)
+}
+
+func (t *Transport) maxIdleConnsPerHost() int {
+	if t.MaxIdleConnsPerHost > 0 {
+		return t.MaxIdleConnsPerHost
+	}
+	return defaultMaxIdleConnsPerHost
+}
+
+func (t *Transport) idleConnTimeout() time.Duration {
+	if t.IdleConnTimeout > 0 {
+		return t.IdleConnTimeout
+	}
+	return defaultIdleConnTimeout
+}
+
+func (t *Transport) maxResponseHeaderBytes() int64 {
+	if t.MaxResponseHeaderBytes > 0 {
+		return t.MaxResponseHeaderBytes
+	}
+	return defaultMaxResponseHeaderBytes
+}
+
+// roundTrip writes req and reads the response headers. On success the
+// connection belongs to the response body until it is read or closed.
+func (pc *persistConn) roundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	// A deadline in the past wakes up any blocked read or write once the
+	// request is canceled.
+	stop := context.AfterFunc(ctx, func() {
+		_ = pc.conn.SetDeadline(time.Unix(1, 0))
+	})
+	fail := func(err error) (*http.Response, error) {
+		stop()
+		pc.close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	closeConn := pc.t.DisableKeepAlives || req.Close || http1.HasToken(req.Header, "Connection", "close")
+	// Bodies are sent while we wait for the response, the server may answer
+	// before reading all of it, e.g. with 413 Content Too Large.
+	var writeDone chan error
+	if hasBody(req) {
+		writeDone = make(chan error, 1)
+		go func() { writeDone <- writeRequest(pc.conn, req, closeConn) }()
+	} else if err := writeRequest(pc.conn, req, closeConn); err != nil {
+		if pc.reused {
+			// The server reset the connection while it was idle.
+			err = fmt.Errorf("%w: %w", errServerClosedIdle, err)
+		}
+		return fail(err)
+	}
+
+	resp, err := pc.readResponse(req)
+	if err != nil {
+		if writeDone != nil {
+			// Closing the connection ends the write, its error is the
+			// more telling one.
+			pc.close()
+			if writeErr := <-writeDone; writeErr != nil && !errors.Is(err, errServerClosedIdle) {
+				err = writeErr
+			}
+		}
+		return fail(err)
+	}
+
+	keepAlive := !closeConn && !resp.Close
+	if resp.Body == http.NoBody {
+		pc.release(keepAlive, stop, writeDone)
+		return resp, nil
+	}
+	resp.Body = &body{
+		r:         resp.Body,
+		ctx:       ctx,
+		pc:        pc,
+		keepAlive: keepAlive,
+		stop:      stop,
+		writeDone: writeDone,
+	}
+	return resp, nil
+}
+
+// release hands pc back to the pool once a response is done, or closes it.
+// stop ends the watch on the request context, writeDone reports the result
+// of writing the request body if it is written concurrently.
+func (pc *persistConn) release(keepAlive bool, stop func() bool, writeDone chan error) {
+	if !stop() {
+		// The request was canceled, the deadline is in the past.
+		keepAlive = false
+	}
+	if writeDone != nil {
+		select {
+		case err := <-writeDone:
+			keepAlive = keepAlive && err == nil
+		default:
+			// The server answered without reading the whole body, the rest
+			// of it would be read as the next request.
+			keepAlive = false
+		}
+	}
+	if !keepAlive {
+		pc.close()
+		return
+	}
+	pc.t.putIdle(pc)
+}
+
+func (pc *persistConn) close() {
+	_ = pc.conn.Close()
+}
+
+// body is a response body that releases the connection when it is done.
+type body struct {
+	r         io.Reader
+	ctx       context.Context
+	pc        *persistConn
+	keepAlive bool
+	stop      func() bool
+	writeDone chan error
+
+	once sync.Once
+	err  error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.r.Read(p)
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF):
+		b.err = io.EOF
+		b.finish(b.keepAlive)
+	default:
+		if ctxErr := b.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		b.err = err
+		b.finish(false)
+	}
+	return n, err
+}
+
+// Close closes the connection unless the body was read to EOF, the server
+// would keep sending what's left of it.
+func (b *body) Close() error {
+	b.finish(false)
+	return nil
+}
+
+func (b *body) finish(keepAlive bool) {
+	b.once.Do(func() {
+		b.pc.release(keepAlive, b.stop, b.writeDone)
+	})
+}
+
+// hostPort adds the default port of scheme to host if it has none.
+func hostPort(scheme, host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "80"
+	if scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// hasBody reports whether req has a body to send.
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody
+}
+
+// rewindable reports whether req can be sent again: it is idempotent, or
+// marked with an idempotency key like net/http does, and its body can be
+// recreated.
+func rewindable(req *http.Request) bool {
+	if hasBody(req) && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// rewind returns a copy of req with a fresh body from GetBody.
+func rewind(req *http.Request) (*http.Request, error) {
+	if !hasBody(req) {
+		return req, nil
+	}
+	newBody, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r2 := *req
+	r2.Body = newBody
+	return &r2, nil
+}
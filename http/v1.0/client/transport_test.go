@@ -0,0 +1,442 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer starts a net/http server that counts the connections it
+// accepts.
+func countingServer(t *testing.T, handler http.Handler) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+// rawServer serves each connection with serve, to send responses net/http
+// wouldn't.
+func rawServer(t *testing.T, serve func(conn net.Conn, br *bufio.Reader)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				serve(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return string(body)
+}
+
+func TestTransport(t *testing.T) {
+	srv, _ := countingServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Transfer-Encoding", strings.Join(r.TransferEncoding, ","))
+		w.Header().Set("X-Content-Length", fmt.Sprint(r.ContentLength))
+		switch r.URL.Path {
+		case "/chunked":
+			// Flushing sends the headers before the length is known.
+			_, _ = io.WriteString(w, "hello ")
+			w.(http.Flusher).Flush()
+			_, _ = io.WriteString(w, "world")
+		case "/trailer":
+			w.Header().Set("Trailer", "X-Checksum")
+			_, _ = io.WriteString(w, "hello")
+			w.(http.Flusher).Flush()
+			w.Header().Set("X-Checksum", "42")
+		default:
+			_, _ = w.Write(body)
+		}
+	}))
+	client := &http.Client{Transport: &Transport{}}
+
+	for _, tt := range []struct {
+		name       string
+		method     string
+		path       string
+		body       io.Reader
+		wantBody   string
+		wantHeader http.Header
+	}{
+		{
+			name:       "get",
+			method:     http.MethodGet,
+			path:       "/",
+			wantHeader: http.Header{"X-Method": {"GET"}, "X-Content-Length": {"0"}},
+		},
+		{
+			name:       "post with length",
+			method:     http.MethodPost,
+			path:       "/",
+			body:       strings.NewReader("hello"),
+			wantBody:   "hello",
+			wantHeader: http.Header{"X-Content-Length": {"5"}, "X-Transfer-Encoding": {""}},
+		},
+		{
+			name:   "post with unknown length",
+			method: http.MethodPost,
+			path:   "/",
+			// Hides the length from http.NewRequest.
+			body:       io.MultiReader(strings.NewReader("hello")),
+			wantBody:   "hello",
+			wantHeader: http.Header{"X-Content-Length": {"-1"}, "X-Transfer-Encoding": {"chunked"}},
+		},
+		{
+			name:       "chunked response",
+			method:     http.MethodGet,
+			path:       "/chunked",
+			wantBody:   "hello world",
+			wantHeader: http.Header{"Content-Length": nil},
+		},
+		{
+			name:       "trailer",
+			method:     http.MethodGet,
+			path:       "/trailer",
+			wantBody:   "hello",
+			wantHeader: http.Header{"X-Checksum": nil},
+		},
+		{
+			name:       "head",
+			method:     http.MethodHead,
+			path:       "/",
+			wantHeader: http.Header{"X-Method": {"HEAD"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, tt.body)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("do: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if body := readBody(t, resp); body != tt.wantBody {
+				t.Fatalf("body = %q, want %q", body, tt.wantBody)
+			}
+			for k, want := range tt.wantHeader {
+				if got := resp.Header.Values(k); strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Fatalf("header %s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("trailer values", func(t *testing.T) {
+		resp, err := client.Get(srv.URL + "/trailer")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		readBody(t, resp)
+		if got := resp.Trailer.Get("X-Checksum"); got != "42" {
+			t.Fatalf("trailer = %q, want %q", got, "42")
+		}
+	})
+}
+
+func TestTransportReusesConnections(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			w.(http.Flusher).Flush()
+		}
+		_, _ = io.WriteString(w, "hello")
+	})
+	for _, tt := range []struct {
+		name      string
+		transport *Transport
+		path      string
+		wantConns int32
+	}{
+		{name: "keep-alive", transport: &Transport{}, path: "/", wantConns: 1},
+		{name: "chunked", transport: &Transport{}, path: "/chunked", wantConns: 1},
+		{name: "disabled", transport: &Transport{DisableKeepAlives: true}, path: "/", wantConns: 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, conns := countingServer(t, handler)
+			for range 3 {
+				req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+				if err != nil {
+					t.Fatalf("new request: %v", err)
+				}
+				resp, err := tt.transport.RoundTrip(req)
+				if err != nil {
+					t.Fatalf("round trip: %v", err)
+				}
+				if body := readBody(t, resp); body != "hello" {
+					t.Fatalf("body = %q, want %q", body, "hello")
+				}
+			}
+			if got := conns.Load(); got != tt.wantConns {
+				t.Fatalf("connections = %d, want %d", got, tt.wantConns)
+			}
+		})
+	}
+}
+
+func TestTransportUnreadBodyClosesConnection(t *testing.T) {
+	srv, conns := countingServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	}))
+	tr := &Transport{}
+	for range 2 {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("round trip: %v", err)
+		}
+		// The rest of the body would be read as the next response.
+		_ = resp.Body.Close()
+	}
+	if got := conns.Load(); got != 2 {
+		t.Fatalf("connections = %d, want 2", got)
+	}
+}
+
+func TestTransportCloseDelimited(t *testing.T) {
+	addr := rawServer(t, func(conn net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nhello, until the end")
+	})
+	resp, err := (&http.Client{Transport: &Transport{}}).Get(addr)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !resp.Close || resp.ContentLength != -1 {
+		t.Fatalf("close = %v, content length = %d, want true, -1", resp.Close, resp.ContentLength)
+	}
+	if body := readBody(t, resp); body != "hello, until the end" {
+		t.Fatalf("body = %q, want %q", body, "hello, until the end")
+	}
+}
+
+func TestTransportSkipsInformational(t *testing.T) {
+	addr := rawServer(t, func(conn net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	})
+	resp, err := (&http.Client{Transport: &Transport{}}).Get(addr)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Status != "200 OK" {
+		t.Fatalf("status = %q, want %q", resp.Status, "200 OK")
+	}
+	if body := readBody(t, resp); body != "ok" {
+		t.Fatalf("body = %q, want %q", body, "ok")
+	}
+}
+
+func TestTransportRetriesClosedIdleConnection(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		method    string
+		header    http.Header
+		wantConns int32
+	}{
+		{name: "GET", method: http.MethodGet, wantConns: 2},
+		// The server may have processed the request before closing.
+		{name: "POST", method: http.MethodPost, wantConns: 1},
+		{name: "POST with idempotency key", method: http.MethodPost, header: http.Header{"Idempotency-Key": {"1"}}, wantConns: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var conns atomic.Int32
+			addr := rawServer(t, func(conn net.Conn, br *bufio.Reader) {
+				conns.Add(1)
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				_, _ = io.Copy(io.Discard, req.Body)
+				// Claims keep-alive, but closes the connection anyway.
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+			})
+			tr := &Transport{}
+			for i := range 2 {
+				// NewRequest sets GetBody, so the body could be sent again.
+				req, err := http.NewRequest(tt.method, addr, strings.NewReader("body"))
+				if err != nil {
+					t.Fatalf("new request: %v", err)
+				}
+				for k, v := range tt.header {
+					req.Header[k] = v
+				}
+				resp, err := tr.RoundTrip(req)
+				if i == 1 && tt.wantConns == 1 {
+					if !errors.Is(err, errServerClosedIdle) {
+						t.Fatalf("round trip error = %v, want %v", err, errServerClosedIdle)
+					}
+					break
+				}
+				if err != nil {
+					t.Fatalf("round trip: %v", err)
+				}
+				if body := readBody(t, resp); body != "ok" {
+					t.Fatalf("body = %q, want %q", body, "ok")
+				}
+			}
+			if got := conns.Load(); got != tt.wantConns {
+				t.Fatalf("connections = %d, want %d", got, tt.wantConns)
+			}
+		})
+	}
+}
+
+func TestTransportInvalidResponse(t *testing.T) {
+	for _, raw := range []string{
+		"HTTP/1.1 200\r\n",
+		"HTTP/2.0 200 OK\r\n\r\n",
+		"HTTP/1.1 2000 OK\r\n\r\n",
+		"garbage\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 1, 2\r\n\r\nx",
+		"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+	} {
+		t.Run(raw, func(t *testing.T) {
+			addr := rawServer(t, func(conn net.Conn, br *bufio.Reader) {
+				if _, err := http.ReadRequest(br); err != nil {
+					return
+				}
+				_, _ = io.WriteString(conn, raw)
+			})
+			req, err := http.NewRequest(http.MethodGet, addr, nil)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			resp, err := (&Transport{}).RoundTrip(req)
+			if err == nil {
+				_ = resp.Body.Close()
+				t.Fatalf("expected error, got %s", resp.Status)
+			}
+		})
+	}
+}
+
+func TestTransportTruncatedBody(t *testing.T) {
+	addr := rawServer(t, func(conn net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort")
+	})
+	resp, err := (&http.Client{Transport: &Transport{}}).Get(addr)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if _, err := io.ReadAll(resp.Body); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("read error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestTransportContext(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	srv, _ := countingServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/body" {
+			_, _ = io.WriteString(w, "start")
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	tr := &Transport{}
+
+	t.Run("headers", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		if _, err := tr.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("body", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/body", nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("round trip: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		time.AfterFunc(50*time.Millisecond, cancel)
+		if _, err := io.ReadAll(resp.Body); !errors.Is(err, context.Canceled) {
+			t.Fatalf("read error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestTransportTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host)
+	}))
+	t.Cleanup(srv.Close)
+	tr := &Transport{TLSClientConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig}
+
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if resp.TLS == nil || !resp.TLS.HandshakeComplete {
+		t.Fatalf("TLS = %v, want a completed handshake", resp.TLS)
+	}
+	if body := readBody(t, resp); body != strings.TrimPrefix(srv.URL, "https://") {
+		t.Fatalf("body = %q, want %q", body, strings.TrimPrefix(srv.URL, "https://"))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/client"
+)
+
+func main() {
+	method := flag.String("X", http.MethodGet, "request method")
+	data := flag.String("d", "", "request body, sent with POST unless -X is set")
+	include := flag.Bool("i", false, "print the status line and headers")
+	insecure := flag.Bool("k", false, "skip verifying the server certificate")
+	timeout := flag.Duration("timeout", 30*time.Second, "time limit for the whole request")
+	flag.Parse()
+
+	url := "http://127.0.0.1:9000/headers"
+	if flag.NArg() > 0 {
+		url = flag.Arg(0)
+	}
+	methodSet := false
+	flag.Visit(func(f *flag.Flag) { methodSet = methodSet || f.Name == "X" })
+	var body io.Reader
+	if *data != "" {
+		body = strings.NewReader(*data)
+		if !methodSet {
+			*method = http.MethodPost
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, *method, url, body)
+	if err != nil {
+		log.Fatalf("err: %s", err)
+	}
+	c := &client.Client{
+		Transport: &client.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure}, //nolint:gosec // opt-in with -k
+		},
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		log.Fatalf("err: %s", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if *include {
+		fmt.Printf("%s %s\r\n", resp.Proto, resp.Status)
+		if err := resp.Header.Write(os.Stdout); err != nil {
+			log.Fatalf("err: %s", err)
+		}
+		fmt.Print("\r\n")
+	}
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		log.Fatalf("err: %s", err)
+	}
+}
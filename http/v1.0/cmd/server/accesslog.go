@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// accessLog logs one line per request to logger and records it in m, which
+// may be nil. The response writer is wrapped to capture the status and the
+// size of the body.
+func accessLog(logger *slog.Logger, m *metrics, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m != nil {
+			m.requestStarted()
+			defer m.requestFinished()
+		}
+
+		rec := &accessLogWriter{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		duration := time.Since(start)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("proto", r.Proto),
+			slog.Int("status", rec.statusCode()),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("duration", duration),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if rec.hijacked {
+			// The handler spoke another protocol on the connection, the
+			// status and size only cover what it sent through w.
+			attrs = append(attrs, slog.Bool("hijacked", true))
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+		if m != nil {
+			m.observe(r.Method, rec.statusCode(), rec.bytes, duration)
+		}
+	})
+}
+
+// accessLogWriter captures the status and body size of a response. It
+// implements Unwrap so http.ResponseController still reaches the server's
+// writer.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (w *accessLogWriter) WriteHeader(statusCode int) {
+	// Informational responses are followed by the final one.
+	if w.status == 0 && (statusCode >= 200 || statusCode == http.StatusSwitchingProtocols) {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom passes io.Copy through to the server's writer, which may send
+// files without copying them.
+func (w *accessLogWriter) ReadFrom(src io.Reader) (int64, error) {
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(writerOnly{w.ResponseWriter}, src)
+	}
+	w.bytes += n
+	return n, err
+}
+
+// Flush keeps the wrapper usable by handlers that assert http.Flusher.
+func (w *accessLogWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack is implemented rather than left to Unwrap so the hijack can be
+// recorded.
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, brw, err
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *accessLogWriter) statusCode() int {
+	switch {
+	case w.status != 0:
+		return w.status
+	case w.hijacked:
+		// Upgrades like WebSocket write their 101 on the raw connection.
+		return http.StatusSwitchingProtocols
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/websocket"
+)
+
+// recordCollector is a slog.Handler that collects the attributes of each
+// record.
+type recordCollector struct {
+	records chan map[string]slog.Value
+}
+
+func (h *recordCollector) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordCollector) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordCollector) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordCollector) Handle(_ context.Context, r slog.Record) error {
+	attrs := make(map[string]slog.Value)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value
+		return true
+	})
+	h.records <- attrs
+	return nil
+}
+
+func TestAccessLog(t *testing.T) {
+	rec := &recordCollector{records: make(chan map[string]slog.Value, 1)}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "hello")
+	})
+	addr := startServer(t, accessLog(slog.New(rec), nil, handler))
+
+	roundTrip(t, addr, "POST /things?x=1 HTTP/1.1\r\nHost: x\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+
+	attrs := <-rec.records
+	for key, want := range map[string]any{
+		"method": "POST",
+		"path":   "/things",
+		"proto":  "HTTP/1.1",
+		"status": int64(http.StatusCreated),
+		"bytes":  int64(5),
+	} {
+		if got := attrs[key].Any(); got != want {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+	if attrs["remote_addr"].String() == "" {
+		t.Error("remote_addr is empty")
+	}
+	if attrs["duration"].Kind() != slog.KindDuration {
+		t.Errorf("duration kind = %v, want %v", attrs["duration"].Kind(), slog.KindDuration)
+	}
+}
+
+func TestAccessLogHijacked(t *testing.T) {
+	rec := &recordCollector{records: make(chan map[string]slog.Value, 1)}
+	addr := startServer(t, accessLog(slog.New(rec), nil, http.HandlerFunc(websocketEcho)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := websocket.Dial(ctx, "ws://"+addr+"/")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.Close()
+
+	attrs := <-rec.records
+	if got := attrs["status"].Int64(); got != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", got, http.StatusSwitchingProtocols)
+	}
+	if !attrs["hijacked"].Bool() {
+		t.Fatal("hijacked is not set")
+	}
+}
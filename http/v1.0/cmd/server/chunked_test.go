@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChunkedEcho(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.Copy(w, r.Body); err != nil {
+			t.Errorf("copy: %v", err)
+		}
+		// Trailers are only available once the body has been read.
+		_, _ = io.WriteString(w, r.Trailer.Get("X-Checksum"))
+	}))
+
+	// Send more than we buffer, so the response is streamed as well.
+	chunk := strings.Repeat("a", bufferedBodyBytes)
+	raw := "POST /echo HTTP/1.1\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: X-Checksum\r\n" +
+		"Connection: close\r\n\r\n" +
+		fmt.Sprintf("%x\r\n%s\r\n", len(chunk), chunk) +
+		"4\r\nWiki\r\n5\r\npedia\r\n0\r\nX-Checksum: 42\r\n\r\n"
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(roundTrip(t, addr, raw))), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("transfer encoding = %v, want [chunked]", resp.TransferEncoding)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if want := chunk + "Wikipedia42"; string(body) != want {
+		t.Fatalf("body = %q, want %q", body, want)
+	}
+}
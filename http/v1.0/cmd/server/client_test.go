@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/client"
+)
+
+// TestClient runs the client package against this server.
+func TestClient(t *testing.T) {
+	addr := startTestServer(t, &Server{Handler: newMux(t.TempDir()), MaxBodyBytes: 1 << 20})
+	var dials atomic.Int32
+	tr := &client.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	c := &http.Client{Transport: tr}
+
+	// Larger than the server buffers, so the response is chunked.
+	large := strings.Repeat("a", 2*bufferedBodyBytes)
+	for _, tt := range []struct {
+		name       string
+		method     string
+		path       string
+		body       io.Reader
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "small", method: http.MethodPost, path: "/echo", body: strings.NewReader("hello"), wantStatus: http.StatusOK, wantBody: "hello"},
+		{name: "chunked both ways", method: http.MethodPost, path: "/echo", body: io.MultiReader(strings.NewReader(large)), wantStatus: http.StatusOK, wantBody: large},
+		{name: "status", method: http.MethodGet, path: "/status/418", wantStatus: http.StatusTeapot},
+		{name: "no content", method: http.MethodGet, path: "/status/204", wantStatus: http.StatusNoContent},
+		{name: "head", method: http.MethodHead, path: "/echo", wantStatus: http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "http://"+addr+tt.path, tt.body)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("do: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Fatalf("body = %.20q (%d bytes), want %.20q (%d bytes)", body, len(body), tt.wantBody, len(tt.wantBody))
+			}
+		})
+	}
+	if got := dials.Load(); got != 1 {
+		t.Fatalf("dials = %d, want 1", got)
+	}
+
+	// The server answers before reading a body that is too large.
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/echo", strings.NewReader(strings.Repeat("a", 2<<20)))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+// minCompressBytes is the smallest body worth compressing, the gzip header
+// and trailer alone take 18 bytes.
+const minCompressBytes = 256
+
+// compress encodes responses with gzip or deflate when the client accepts
+// it and the content type is worth compressing (RFC 9110 8.4).
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Caches must not hand a compressed response to a client that
+		// can't decode it.
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Values("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer func() {
+			if err := cw.Close(); err != nil {
+				slog.Error("failed to finish compressed response", "error", err)
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from Accept-Encoding header values,
+// whichever has the higher q-value, preferring gzip on a tie. It returns ""
+// if neither is acceptable (RFC 9110 12.5.3).
+func negotiateEncoding(values []string) string {
+	qs := make(map[string]float64)
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			q := 1.0
+			if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					continue
+				}
+				q = parsed
+			}
+			if coding == "x-gzip" {
+				coding = "gzip"
+			}
+			qs[coding] = q
+		}
+	}
+
+	var best string
+	var bestQ float64
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := qs[coding]
+		if !ok {
+			// Codings that aren't listed are only acceptable through
+			// the wildcard.
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether responses of the media type benefit from
+// compression. Images, video and archives are compressed already.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		// Events must reach the client right away.
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "image/svg+xml", "application/wasm":
+		return true
+	}
+	return false
+}
+
+// compressWriter compresses the response body if the response turns out to
+// be eligible, which is decided when the headers are written. Without a
+// Content-Length, the status and the start of the body are held back until
+// there is enough of it to be worth compressing, or the handler returns.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	// status is the code passed to WriteHeader, buf the start of the body,
+	// while we haven't decided.
+	status  int
+	buf     []byte
+	decided bool
+	// enc is nil unless the body is compressed.
+	enc io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(statusCode int) {
+	if statusCode >= 100 && statusCode <= 199 && statusCode != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.decided {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.status != 0 {
+		slog.Warn(fmt.Sprintf("WriteHeader called twice, second time with: %d", statusCode))
+		return
+	}
+	w.status = statusCode
+	if !w.hold(nil) {
+		_ = w.start(nil, false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if w.hold(b) {
+			w.buf = append(w.buf, b...)
+			return len(b), nil
+		}
+		if err := w.start(sniffSample(w.buf, b), false); err != nil {
+			return 0, err
+		}
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.enc.Write(b)
+}
+
+// hold reports whether b can be held back with what's held already, to
+// learn if the body is big enough to compress.
+func (w *compressWriter) hold(b []byte) bool {
+	return http1.BodyAllowed(w.statusCode()) && w.Header().Get("Content-Length") == "" &&
+		len(w.buf)+len(b) < minCompressBytes
+}
+
+func (w *compressWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// start decides whether to compress and sends what was held back. finished
+// reports whether the handler has returned, so the held back body is all
+// of it.
+func (w *compressWriter) start(sample []byte, finished bool) error {
+	w.decide(w.statusCode(), sample, finished)
+	return w.release()
+}
+
+// release sends the status and body held back, compressed if we decided so.
+func (w *compressWriter) release() error {
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// ReadFrom compresses what io.Copy reads, or passes it through to the
+// server's writer if the body is sent as is.
+func (w *compressWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.decided {
+		if _, ok := w.Header()["Content-Type"]; !ok || w.hold(nil) {
+			// Write has to sniff the content type or learn the size
+			// first.
+			return io.Copy(writerOnly{w}, src)
+		}
+		if err := w.start(nil, false); err != nil {
+			return 0, err
+		}
+	}
+	if w.enc != nil {
+		return io.Copy(w.enc, src)
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(writerOnly{w.ResponseWriter}, src)
+}
+
+// decide sets up compression if the response is eligible. sample is the
+// start of the body, used to sniff the content type if it isn't set, and
+// the whole body if finished.
+func (w *compressWriter) decide(statusCode int, sample []byte, finished bool) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	h := w.Header()
+	if !http1.BodyAllowed(statusCode) || statusCode == http.StatusPartialContent {
+		return
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return
+	}
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil && cl < minCompressBytes {
+		return
+	}
+	if finished && len(sample) < minCompressBytes {
+		return
+	}
+	contentType := h.Get("Content-Type")
+	if _, ok := h["Content-Type"]; !ok && len(sample) > 0 {
+		// Sniff now, the server would sniff the compressed bytes.
+		contentType = http.DetectContentType(sample)
+		h.Set("Content-Type", contentType)
+	}
+	if !compressible(contentType) {
+		return
+	}
+
+	h.Set("Content-Encoding", w.encoding)
+	// The length and byte ranges of the compressed body are unknown.
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	// The compressed body differs from the one the entity tag was made
+	// for. A weak tag still validates caches, but never matches If-Range,
+	// which would splice ranges of the identity body into it
+	// (RFC 9110 8.8.3).
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	if w.encoding == "gzip" {
+		w.enc = gzip.NewWriter(w.ResponseWriter)
+	} else {
+		w.enc = zlib.NewWriter(w.ResponseWriter)
+	}
+}
+
+// Close sends what was held back and writes what's left of the compressed
+// body.
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if err := w.start(w.buf, true); err != nil {
+			return err
+		}
+	}
+	if w.enc == nil {
+		return nil
+	}
+	return w.enc.Close()
+}
+
+// Flush sends the data compressed so far.
+func (w *compressWriter) Flush() {
+	_ = w.FlushError()
+}
+
+func (w *compressWriter) FlushError() error {
+	if !w.decided {
+		if err := w.start(w.buf, false); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := w.enc.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack hands over the connection uncompressed.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.enc != nil {
+		return nil, nil, errors.New("compress: hijack after compressed writes")
+	}
+	if !w.decided {
+		w.decided = true
+		if err := w.release(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decompressRequest decodes request bodies sent with Content-Encoding gzip or
+// deflate. Other encodings are answered with 415 Unsupported Media Type
+// (RFC 9110 15.5.16). Reading more than maxBytes of decoded body fails with
+// *http.MaxBytesError, since a small body may inflate enormously.
+func decompressRequest(maxBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings := r.Header.Values("Content-Encoding")
+		if len(encodings) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body io.ReadCloser
+		var err error
+		switch strings.ToLower(strings.TrimSpace(strings.Join(encodings, ","))) {
+		case "gzip", "x-gzip":
+			body, err = gzip.NewReader(r.Body)
+		case "deflate":
+			body, err = zlib.NewReader(r.Body)
+		case "identity":
+			body = r.Body
+		default:
+			w.Header().Set("Accept-Encoding", "gzip, deflate")
+			http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer func() { _ = body.Close() }()
+
+		r2 := r.WithContext(r.Context())
+		r2.Header = r.Header.Clone()
+		r2.Body = http.MaxBytesReader(w, body, maxBytes)
+		r2.ContentLength = -1
+		r2.Header.Del("Content-Encoding")
+		r2.Header.Del("Content-Length")
+		next.ServeHTTP(w, r2)
+	})
+}
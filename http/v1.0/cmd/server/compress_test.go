@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: ""},
+		{accept: "gzip", want: "gzip"},
+		{accept: "x-gzip", want: "gzip"},
+		{accept: "deflate", want: "deflate"},
+		{accept: "gzip, deflate, br", want: "gzip"},
+		{accept: "deflate, gzip", want: "gzip"},
+		{accept: "gzip;q=0.5, deflate", want: "deflate"},
+		{accept: "GZIP ; Q=0.8, deflate;q=0.9", want: "deflate"},
+		{accept: "gzip;q=0", want: ""},
+		{accept: "br", want: ""},
+		{accept: "*", want: "gzip"},
+		{accept: "*;q=0.5, gzip;q=0", want: "deflate"},
+		{accept: "identity", want: ""},
+		{accept: "gzip;q=2", want: ""},
+	} {
+		if got := negotiateEncoding([]string{tt.accept}); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+// getEncoded requests url with the Accept-Encoding header and returns the
+// response with its body decoded.
+func getEncoded(t *testing.T, url, acceptEncoding string, header http.Header) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	// Don't let the transport decode gzip for us.
+	transport := &http.Transport{DisableCompression: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var body io.Reader = resp.Body
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		if body, err = gzip.NewReader(resp.Body); err != nil {
+			t.Fatalf("gzip reader: %v", err)
+		}
+	case "deflate":
+		if body, err = zlib.NewReader(resp.Body); err != nil {
+			t.Fatalf("zlib reader: %v", err)
+		}
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return resp, string(b)
+}
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat("compress me ", 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/text", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, text)
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, `"`+text+`"`)
+	})
+	mux.HandleFunc("/png", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = io.WriteString(w, text)
+	})
+	mux.HandleFunc("/small", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "5")
+		_, _ = io.WriteString(w, "small")
+	})
+	mux.HandleFunc("/teapot", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = io.WriteString(w, "I'm a teapot")
+	})
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, text)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush: %v", err)
+		}
+		_, _ = io.WriteString(w, text)
+	})
+	addr := "http://" + startServer(t, compress(mux))
+
+	for _, tt := range []struct {
+		name         string
+		path         string
+		accept       string
+		wantStatus   int
+		wantEncoding string
+		wantBody     string
+	}{
+		{name: "gzip", path: "/text", accept: "gzip, deflate", wantEncoding: "gzip", wantBody: text},
+		{name: "deflate", path: "/text", accept: "gzip;q=0.1, deflate", wantEncoding: "deflate", wantBody: text},
+		{name: "identity", path: "/text", accept: "identity", wantBody: text},
+		{name: "no accept encoding", path: "/text", wantBody: text},
+		{name: "json", path: "/json", accept: "gzip", wantEncoding: "gzip", wantBody: `"` + text + `"`},
+		{name: "already compressed type", path: "/png", accept: "gzip", wantBody: text},
+		{name: "small", path: "/small", accept: "gzip", wantBody: "small"},
+		{name: "small without length", path: "/teapot", accept: "gzip", wantStatus: http.StatusTeapot, wantBody: "I'm a teapot"},
+		{name: "flushed", path: "/flush", accept: "gzip", wantEncoding: "gzip", wantBody: text + text},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := getEncoded(t, addr+tt.path, tt.accept, nil)
+			if want := cmp.Or(tt.wantStatus, http.StatusOK); resp.StatusCode != want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, want)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want %q", got, "Accept-Encoding")
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %.40q, want %.40q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCompressFileServer(t *testing.T) {
+	public := t.TempDir()
+	content := strings.Repeat("<p>Hello</p>\n", 100)
+	if err := os.WriteFile(filepath.Join(public, "index.html"), []byte(content), 0o600); err != nil {
+		t.Fatalf("write index.html: %v", err)
+	}
+	addr := "http://" + startServer(t, compress(newMux(public)))
+
+	resp, body := getEncoded(t, addr+"/", "gzip", nil)
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := resp.Header.Get("Accept-Ranges"); got != "" {
+		t.Fatalf("Accept-Ranges = %q, want none on a compressed response", got)
+	}
+	if body != content {
+		t.Fatalf("body = %.40q, want %.40q", body, content)
+	}
+	etag := resp.Header.Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("ETag = %q, want a weak tag on a compressed response", etag)
+	}
+
+	// The weak tag still validates the cached copy, but doesn't let a range
+	// of the identity body through If-Range.
+	resp, _ = getEncoded(t, addr+"/", "gzip", http.Header{"If-None-Match": {etag}})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("If-None-Match status = %d, want 304", resp.StatusCode)
+	}
+	resp, body = getEncoded(t, addr+"/", "gzip", http.Header{"Range": {"bytes=0-2"}, "If-Range": {etag}})
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Fatalf("If-Range response = %d %.40q, want 200 with the whole body", resp.StatusCode, body)
+	}
+
+	// Byte ranges apply to the identity encoding.
+	resp, body = getEncoded(t, addr+"/", "gzip", http.Header{"Range": {"bytes=0-2"}})
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Encoding") != "" || body != "<p>" {
+		t.Fatalf("range response = %d %q %q, want 206 with identity body %q",
+			resp.StatusCode, resp.Header.Get("Content-Encoding"), body, "<p>")
+	}
+}
+
+func TestDecompressRequest(t *testing.T) {
+	addr := "http://" + startServer(t, newMux(t.TempDir())) + "/echo"
+	text := strings.Repeat("decompress me ", 100)
+
+	for _, tt := range []struct {
+		encoding string
+		encode   func(io.Writer) io.WriteCloser
+	}{
+		{encoding: "gzip", encode: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+		{encoding: "deflate", encode: func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+	} {
+		t.Run(tt.encoding, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := tt.encode(&buf)
+			_, _ = io.WriteString(enc, text)
+			if err := enc.Close(); err != nil {
+				t.Fatalf("close encoder: %v", err)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, addr, &buf)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			req.Header.Set("Content-Encoding", tt.encoding)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("post: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if string(body) != text {
+				t.Fatalf("echo = %.40q, want %.40q", body, text)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, addr, strings.NewReader("data"))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Content-Encoding", "br")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusUnsupportedMediaType)
+		}
+		if got := resp.Header.Get("Accept-Encoding"); got != "gzip, deflate" {
+			t.Fatalf("Accept-Encoding = %q, want %q", got, "gzip, deflate")
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// conformanceCorpus holds raw requests that are sent to both our server and
+// net/http. Each one must make the server close the connection once it has
+// responded.
+var conformanceCorpus = []struct {
+	name string
+	raw  string
+	// onlyStatus compares only the status of error responses, whose
+	// wording differs.
+	onlyStatus bool
+	// ignoreHeaders are headers only one of the servers sends.
+	ignoreHeaders []string
+	// wantStatus is set where we deliberately differ from net/http, it is
+	// the status we respond with.
+	wantStatus int
+}{
+	{name: "GET", raw: "GET /echo HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "HTTP/1.0", raw: "GET /headers HTTP/1.0\r\nX-Test: a\r\n\r\n"},
+	{name: "HEAD", raw: "HEAD /headers HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "static file", raw: "GET /hello.txt HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "static index", raw: "GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "static range", raw: "GET /hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=7-\r\nConnection: close\r\n\r\n"},
+	{
+		// The boundary is random.
+		name:       "static multiple ranges",
+		raw:        "GET /hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=0-4,7-\r\nConnection: close\r\n\r\n",
+		onlyStatus: true,
+	},
+	{name: "static unsatisfiable range", raw: "GET /hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=99-\r\nConnection: close\r\n\r\n"},
+	{
+		name: "static not modified",
+		raw:  "GET /hello.txt HTTP/1.1\r\nHost: x\r\nIf-Modified-Since: Fri, 01 Jan 2100 00:00:00 GMT\r\nConnection: close\r\n\r\n",
+	},
+	{name: "not found", raw: "GET /missing HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "redirect", raw: "GET /a/../echo HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "status", raw: "GET /status/418 HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "no content", raw: "GET /status/204 HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "not modified", raw: "GET /status/304 HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "invalid status", raw: "GET /status/abc HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "empty handler", raw: "GET /nothing HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{
+		name: "headers",
+		raw:  "GET /headers HTTP/1.1\r\nHost: x\r\nx-lower: a\r\nX-Multi: 1\r\nx-multi: 2\r\nX-Space:  b \t\r\nConnection: close\r\n\r\n",
+	},
+	{name: "echo body", raw: "POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello"},
+	{
+		name: "echo large body",
+		raw: "POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 10000\r\nConnection: close\r\n\r\n" +
+			strings.Repeat("abcdefghij", 1000),
+	},
+	{
+		name: "echo chunked body",
+		raw:  "POST /echo HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+	},
+	{
+		name: "pipelined",
+		raw: "GET /echo HTTP/1.1\r\nHost: x\r\n\r\n" +
+			"POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /nothing HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n",
+	},
+	{
+		name:          "OPTIONS *",
+		raw:           "OPTIONS * HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n",
+		ignoreHeaders: []string{"Allow"},
+	},
+	{name: "absolute-form", raw: "GET http://example.com/headers HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"},
+	{name: "missing target", raw: "GET\r\n\r\n"},
+	{name: "invalid target", raw: "GET foo HTTP/1.1\r\nHost: x\r\n\r\n"},
+	{name: "invalid version", raw: "GET / HTTP/x.y\r\nHost: x\r\n\r\n"},
+	{name: "unsupported version", raw: "GET / HTTP/2.0\r\nHost: x\r\n\r\n", onlyStatus: true},
+	{name: "header without colon", raw: "GET / HTTP/1.1\r\nHost x\r\n\r\n"},
+	{
+		// net/http replaces the fold with a space, we reject it.
+		name:       "obsolete line folding",
+		raw:        "GET / HTTP/1.1\r\nHost: x\r\nX-Folded: a\r\n b\r\nConnection: close\r\n\r\n",
+		wantStatus: http.StatusBadRequest,
+	},
+	{name: "whitespace before colon", raw: "GET / HTTP/1.1\r\nHost : x\r\n\r\n", onlyStatus: true},
+	{name: "invalid content length", raw: "POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: abc\r\n\r\n"},
+	{
+		name: "conflicting content lengths",
+		raw:  "POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab",
+	},
+	{name: "multiple Host headers", raw: "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n"},
+	{
+		// net/http ignores Transfer-Encoding in HTTP/1.0 and reads the
+		// chunks as the next request. We can't trust the framing either,
+		// so we close the connection after the body and never read the
+		// request after it.
+		name: "HTTP/1.0 chunked body",
+		raw: "POST /echo HTTP/1.0\r\nTransfer-Encoding: chunked\r\nConnection: keep-alive\r\n\r\n5\r\nhello\r\n0\r\n\r\n" +
+			"GET /echo HTTP/1.0\r\n\r\n",
+		wantStatus: http.StatusOK,
+	},
+	{
+		name:       "unsupported transfer encoding",
+		raw:        "POST /echo HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: gzip\r\n\r\n",
+		onlyStatus: true,
+	},
+	{
+		name:       "unsupported expectation",
+		raw:        "POST /echo HTTP/1.1\r\nHost: x\r\nExpect: magic\r\nContent-Length: 0\r\n\r\n",
+		onlyStatus: true,
+	},
+}
+
+// conformanceResponse is the part of a response that must be the same for
+// both servers.
+type conformanceResponse struct {
+	Status int
+	Header http.Header
+	Body   string
+	Close  bool
+}
+
+// ignoredHeaders differ between any two responses, or are left to each
+// server's discretion.
+var ignoredHeaders = []string{"Date", "Content-Length", "Transfer-Encoding"}
+
+func TestConformance(t *testing.T) {
+	public := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html": "<h1>Hello</h1>",
+		"hello.txt":  "Hello, World",
+	} {
+		if err := os.WriteFile(filepath.Join(public, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	mux := newMux(public)
+	ours := startServer(t, mux)
+	theirs := startNetHTTPServer(t, mux)
+
+	for _, tt := range conformanceCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readResponses(t, roundTrip(t, ours, tt.raw), tt.raw, tt.ignoreHeaders)
+			if tt.wantStatus != 0 {
+				if len(got) != 1 || got[0].Status != tt.wantStatus {
+					t.Fatalf("responses = %+v, want status %d", got, tt.wantStatus)
+				}
+				return
+			}
+			want := readResponses(t, roundTrip(t, theirs, tt.raw), tt.raw, tt.ignoreHeaders)
+			if tt.onlyStatus {
+				for _, resps := range [][]conformanceResponse{got, want} {
+					for i := range resps {
+						resps[i].Header, resps[i].Body = nil, ""
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("responses differ from net/http\ngot:  %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+// startNetHTTPServer serves handler with net/http until the test ends and
+// returns its address.
+func startNetHTTPServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	var lc net.ListenConfig
+	lis, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &http.Server{Handler: handler} //nolint:gosec // test server
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(func() { _ = s.Close() })
+	return lis.Addr().String()
+}
+
+// readResponses parses all responses in raw, which answer the requests in
+// rawRequests. The ignored headers are removed, along with ignoredHeaders.
+func readResponses(t *testing.T, raw, rawRequests string, ignore []string) []conformanceResponse {
+	t.Helper()
+
+	// The method tells whether a response has a body.
+	method := strings.Fields(rawRequests + " GET")[0]
+	br := bufio.NewReader(strings.NewReader(raw))
+	var responses []conformanceResponse
+	for {
+		if _, err := br.Peek(1); errors.Is(err, io.EOF) {
+			return responses
+		}
+		resp, err := http.ReadResponse(br, &http.Request{Method: method})
+		if err != nil {
+			t.Fatalf("read response: %v\n%s", err, raw)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read body: %v\n%s", err, raw)
+		}
+		for _, h := range slices.Concat(ignoredHeaders, ignore) {
+			resp.Header.Del(h)
+		}
+		responses = append(responses, conformanceResponse{
+			Status: resp.StatusCode,
+			Header: resp.Header,
+			Body:   string(body),
+			Close:  resp.Close,
+		})
+	}
+}
+
+// FuzzRequestLineConformance checks that every request line we accept is read
+// the same way by net/http.
+func FuzzRequestLineConformance(f *testing.F) {
+	for _, tt := range conformanceCorpus {
+		line, _, _ := strings.Cut(tt.raw, "\r\n")
+		f.Add(line)
+	}
+	f.Add("CONNECT example.com:443 HTTP/1.1")
+	f.Add("GET http://[::1]:8080/a?b HTTP/1.0")
+	f.Fuzz(func(t *testing.T, line string) {
+		raw := line + "\r\n\r\n"
+		req, err := readRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			return
+		}
+		want, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatalf("net/http rejects %q: %v", line, err)
+		}
+		got := []any{req.Method, req.RequestURI, req.URL.String(), req.Proto, req.Host}
+		if w := []any{want.Method, want.RequestURI, want.URL.String(), want.Proto, want.Host}; !reflect.DeepEqual(got, w) {
+			t.Fatalf("%q read as %q, net/http reads %q", line, got, w)
+		}
+	})
+}
+
+// FuzzHeaderConformance checks that all headers we accept are read the same
+// way by net/http.
+func FuzzHeaderConformance(f *testing.F) {
+	for _, tt := range conformanceCorpus {
+		_, header, _ := strings.Cut(tt.raw, "\r\n")
+		header, _, _ = strings.Cut(header, "\r\n\r\n")
+		f.Add(header + "\r\n")
+	}
+	f.Add("Content-Length: 3\r\nContent-Length: 3\r\n")
+	f.Add("Content-Length: 3, 3\r\n")
+	f.Fuzz(func(t *testing.T, header string) {
+		raw := "POST / HTTP/1.1\r\n" + header + "\r\n"
+		req, err := readRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			return
+		}
+		want, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			// net/http rejects lists of identical lengths, which RFC 9110
+			// 8.6 allows.
+			if hasContentLengthList(header) {
+				return
+			}
+			t.Fatalf("net/http rejects %q: %v", header, err)
+		}
+		got := []any{req.Header, req.Host, req.ContentLength, req.TransferEncoding}
+		if w := []any{want.Header, want.Host, want.ContentLength, want.TransferEncoding}; !reflect.DeepEqual(got, w) {
+			t.Fatalf("%q read as %q, net/http reads %q", header, got, w)
+		}
+	})
+}
+
+// hasContentLengthList reports whether a Content-Length header in the raw
+// header lines holds a comma separated list.
+func hasContentLengthList(header string) bool {
+	for _, line := range strings.Split(header, "\n") {
+		k, v, _ := strings.Cut(line, ":")
+		if strings.EqualFold(k, "Content-Length") && strings.Contains(v, ",") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+// defaultMaxHeaderBytes limits the size of the request line and headers if
+// Server.MaxHeaderBytes is not set.
+const defaultMaxHeaderBytes = 1 * 1024 * 1024
+
+func (s *Server) handleConnection(handler http.Handler, conn net.Conn) error {
+	// A hijacked connection belongs to the handler.
+	var hijacked bool
+	defer func() {
+		if !hijacked {
+			_ = conn.Close()
+		}
+	}()
+
+	// The reader is shared by all requests on the connection, so bytes of a
+	// pipelined request that were buffered while reading the previous one
+	// are not lost.
+	limitReader := io.LimitReader(conn, s.maxHeaderBytes()).(*io.LimitedReader)
+	reader := bufio.NewReader(limitReader)
+
+	baseCtx := s.baseContext()
+	tlsState, err := s.tlsHandshake(baseCtx, conn)
+	if err != nil {
+		return fmt.Errorf("TLS handshake error: %w", err)
+	}
+
+	// A new connection is about to send its first request, it only counts
+	// as idle between requests.
+	waiting := stateNew
+	for first := true; ; first = false {
+		limitReader.N = s.maxHeaderBytes()
+
+		// Wait for the next request while marked as idle, so Shutdown
+		// can close the connection without interrupting a request.
+		if !s.trackConn(conn, waiting) {
+			return nil
+		}
+		waiting = stateIdle
+		if err := conn.SetReadDeadline(deadline(time.Now(), s.idleTimeout())); err != nil {
+			return err
+		}
+		if _, err := reader.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+				// The client closed the connection between requests, it
+				// was idle for too long, or we closed it while shutting
+				// down.
+				return nil
+			}
+			return err
+		}
+		if !s.trackConn(conn, stateActive) {
+			return nil
+		}
+
+		// The read timeouts are measured from the first byte of the
+		// request, so a slow client can't hold on to the connection by
+		// trickling in headers.
+		reqStart := time.Now()
+		if err := conn.SetReadDeadline(deadline(reqStart, s.readHeaderTimeout())); err != nil {
+			return err
+		}
+		if s.H2C && first && tlsState == nil && hasH2CPreface(reader) {
+			// HTTP/2 limits header lists itself, the frames after the
+			// preface must not be cut off.
+			limitReader.N = math.MaxInt64
+			return s.serveH2C(handler, conn, reader)
+		}
+		req, err := readRequest(reader)
+		if err != nil {
+			var reqErr *requestError
+			switch {
+			case limitReader.N <= 0:
+				reqErr = &requestError{statusCode: http.StatusRequestHeaderFieldsTooLarge, err: err}
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				reqErr = &requestError{statusCode: http.StatusRequestTimeout, err: err}
+			case errors.As(err, &reqErr):
+			default:
+				return err
+			}
+			if err := writeErrorResponse(conn, reqErr); err != nil {
+				return errors.Join(reqErr, err)
+			}
+			return reqErr
+		}
+		if err := conn.SetReadDeadline(deadline(reqStart, s.ReadTimeout)); err != nil {
+			return err
+		}
+		if err := conn.SetWriteDeadline(deadline(time.Now(), s.WriteTimeout)); err != nil {
+			return err
+		}
+
+		if ok, wait := s.allowRequest(conn); !ok {
+			reqErr := &requestError{
+				statusCode: http.StatusServiceUnavailable,
+				header:     http.Header{"Retry-After": {retryAfter(wait)}},
+				err:        fmt.Errorf("rate limit exceeded for %s", remoteIP(conn)),
+			}
+			if err := writeErrorResponse(conn, reqErr); err != nil {
+				return errors.Join(reqErr, err)
+			}
+			return reqErr
+		}
+		if s.MaxBodyBytes > 0 && req.ContentLength > s.MaxBodyBytes {
+			reqErr := &requestError{
+				statusCode: http.StatusRequestEntityTooLarge,
+				err:        fmt.Errorf("content length %d exceeds %d bytes", req.ContentLength, s.MaxBodyBytes),
+			}
+			if err := writeErrorResponse(conn, reqErr); err != nil {
+				return errors.Join(reqErr, err)
+			}
+			return reqErr
+		}
+
+		// Unbound the limit after we've read the headers, the body is
+		// limited by MaxBodyBytes instead.
+		limitReader.N = math.MaxInt64
+
+		req.RemoteAddr = conn.RemoteAddr().String()
+		req.TLS = tlsState
+		req.Close = !shouldKeepAlive(req) || s.shuttingDown()
+
+		ctx := context.WithValue(baseCtx, http.LocalAddrContextKey, conn.LocalAddr())
+		ctx, cancelCtx := context.WithCancel(ctx)
+		// The handler gets this very request, so we see the multipart form
+		// it may parse.
+		req = req.WithContext(ctx)
+
+		w := &responseBodyWriter{
+			// Reply with the version the client spoke, so HTTP/1.0 clients
+			// never see HTTP/1.1 features they don't understand.
+			proto:   responseProto(req),
+			conn:    conn,
+			reader:  reader,
+			req:     req,
+			headers: make(http.Header),
+		}
+		if body, ok := req.Body.(*bodyReader); ok && req.ContentLength < 0 && s.MaxBodyBytes > 0 {
+			// Like net/http, we can't read the next request once the body
+			// is cut off.
+			body.reader = &maxBytesReader{
+				reader:     body.reader,
+				limit:      s.MaxBodyBytes,
+				n:          s.MaxBodyBytes,
+				onExceeded: func() { req.Close = true },
+			}
+		}
+		if req.ProtoAtLeast(1, 1) && req.ContentLength != 0 && http1.HasToken(req.Header, "Expect", "100-continue") {
+			w.expectContinue = true
+			req.Body = &expectContinueReader{body: req.Body, w: w}
+		}
+
+		// Without a body to read, we watch the connection while the handler
+		// runs, so the request context is canceled if the client goes away.
+		if _, ok := req.Body.(noBody); ok {
+			w.bgRead = startBackgroundRead(conn, reader, cancelCtx)
+		}
+
+		// Finally, call our http.Handler!
+		h := handler
+		if req.RequestURI == "*" && !s.DisableGeneralOptionsHandler {
+			h = http.HandlerFunc(generalOptionsHandler)
+		}
+		h.ServeHTTP(w, req)
+		cancelCtx()
+		if req.MultipartForm != nil {
+			_ = req.MultipartForm.RemoveAll()
+		}
+		if w.hijacked {
+			hijacked = true
+			return nil
+		}
+		clientGone := w.stopBackgroundRead() != nil
+		if err := w.finishRequest(); err != nil {
+			return err
+		}
+
+		if w.expectContinue && !w.sentContinue {
+			// The client is still waiting for permission to send the
+			// body, there is nothing to drain.
+			return nil
+		}
+
+		// Drain what's left of the body so the next request starts at the
+		// right offset.
+		if err := req.Body.Close(); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil
+			}
+			return err
+		}
+		if w.closeAfter || clientGone || s.shuttingDown() {
+			return nil
+		}
+	}
+}
+
+// generalOptionsHandler answers "OPTIONS *", which asks about the server as
+// a whole rather than a resource (RFC 9110 9.3.7).
+func generalOptionsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Allow", strings.Join(supportedMethods, ", "))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
+// backgroundRead waits for the client to send more data while a handler
+// runs. The data itself stays in the bufio.Reader for the next request, but
+// an error means the client went away.
+type backgroundRead struct {
+	conn net.Conn
+	done chan struct{}
+	err  error
+}
+
+func startBackgroundRead(conn net.Conn, reader *bufio.Reader, cancel context.CancelFunc) *backgroundRead {
+	b := &backgroundRead{conn: conn, done: make(chan struct{})}
+	go func() {
+		defer close(b.done)
+		_, err := reader.Peek(1)
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			b.err = err
+			cancel()
+		}
+	}()
+	return b
+}
+
+// stop interrupts the background read and returns the error that ended it,
+// if the client went away. The caller must reset the read deadline.
+func (b *backgroundRead) stop() error {
+	// A deadline in the past wakes up the blocked read.
+	_ = b.conn.SetReadDeadline(time.Unix(1, 0))
+	<-b.done
+	return b.err
+}
+
+// deadline returns the deadline d after t, or the zero time (no deadline) if d
+// is not set.
+func deadline(t time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return t.Add(d)
+}
+
+// shouldKeepAlive reports whether the connection may be reused after
+// responding to req. HTTP/1.1 connections are persistent unless the client
+// asks to close them, HTTP/1.0 connections only persist on request
+// (RFC 9112 9.3).
+func shouldKeepAlive(req *http.Request) bool {
+	if req.ProtoAtLeast(1, 1) {
+		return !http1.HasToken(req.Header, "Connection", "close")
+	}
+	// HTTP/1.0 has no Transfer-Encoding. A proxy in front of us may have
+	// delimited the body differently, so we can't trust what follows it
+	// (RFC 9112 6.1).
+	if len(req.TransferEncoding) > 0 {
+		return false
+	}
+	return http1.HasToken(req.Header, "Connection", "keep-alive")
+}
+
+func responseProto(req *http.Request) string {
+	if req.ProtoAtLeast(1, 1) {
+		return "HTTP/1.1"
+	}
+	return "HTTP/1.0"
+}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+type bodyReader struct {
+	reader io.Reader
+}
+
+func (r *bodyReader) Read(p []byte) (n int, err error) {
+	return r.reader.Read(p)
+}
+
+func (r *bodyReader) Close() error {
+	_, err := io.Copy(io.Discard, r.reader)
+	return err
+}
+
+// maxBytesReader fails reads past limit bytes with *http.MaxBytesError, like
+// http.MaxBytesReader. onExceeded is called when that happens.
+type maxBytesReader struct {
+	reader     io.Reader
+	limit      int64
+	n          int64 // bytes left
+	err        error
+	onExceeded func()
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// Read one byte more than allowed, to tell a body of exactly the
+	// limit from a longer one.
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1]
+	}
+	n, err := m.reader.Read(p)
+	if int64(n) <= m.n {
+		m.n -= int64(n)
+		m.err = err
+		return n, err
+	}
+	n = int(m.n)
+	m.n = 0
+	m.err = &http.MaxBytesError{Limit: m.limit}
+	m.onExceeded()
+	return n, m.err
+}
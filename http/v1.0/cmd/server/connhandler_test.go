@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepAlive(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "2")
+		_, _ = io.WriteString(w, "ok")
+	}))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	br := bufio.NewReader(conn)
+
+	for i := range 3 {
+		if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"); err != nil {
+			t.Fatalf("write request %d: %v", i, err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read response %d: %v", i, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read body %d: %v", i, err)
+		}
+		if resp.Proto != "HTTP/1.1" {
+			t.Fatalf("proto = %q, want HTTP/1.1", resp.Proto)
+		}
+		if string(body) != "ok" {
+			t.Fatalf("body = %q, want %q", body, "ok")
+		}
+		if resp.Close {
+			t.Fatalf("response %d asked to close the connection", i)
+		}
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1")
+		_, _ = io.WriteString(w, r.URL.Path[1:])
+	}))
+
+	raw := "GET /a HTTP/1.1\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nContent-Length: 3\r\n\r\nxyz" +
+		"GET /c HTTP/1.1\r\nConnection: close\r\n\r\n"
+	resp := roundTrip(t, addr, raw)
+
+	br := bufio.NewReader(strings.NewReader(resp))
+	var got string
+	for range 3 {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		got += string(body)
+	}
+	if got != "abc" {
+		t.Fatalf("bodies = %q, want %q", got, "abc")
+	}
+}
+
+func TestConnectionHeader(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for _, tt := range []struct {
+		name      string
+		raw       string
+		wantProto string
+		wantClose bool
+	}{
+		{
+			name:      "http/1.0 closes by default",
+			raw:       "GET / HTTP/1.0\r\n\r\n",
+			wantProto: "HTTP/1.0",
+			wantClose: true,
+		},
+		{
+			name:      "http/1.0 keep-alive",
+			raw:       "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET / HTTP/1.0\r\n\r\n",
+			wantProto: "HTTP/1.0",
+			wantClose: false,
+		},
+		{
+			name:      "http/1.1 close",
+			raw:       "GET / HTTP/1.1\r\nConnection: close\r\n\r\n",
+			wantProto: "HTTP/1.1",
+			wantClose: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(roundTrip(t, addr, tt.raw))), nil)
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			if resp.Proto != tt.wantProto {
+				t.Fatalf("proto = %q, want %q", resp.Proto, tt.wantProto)
+			}
+			if resp.Close != tt.wantClose {
+				t.Fatalf("close = %t, want %t", resp.Close, tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		Handler:           http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		ReadHeaderTimeout: 50 * time.Millisecond,
+	})
+
+	// Never finish the headers, like a slowloris client.
+	resp := roundTrip(t, addr, "GET / HTTP/1.1\r\nHost: example.com\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 408 Request Timeout\r\n") {
+		t.Fatalf("response = %q, want 408", resp)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		Handler:     http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		IdleTimeout: 50 * time.Millisecond,
+	})
+
+	// The server closes the idle connection without a response.
+	resp := roundTrip(t, addr, "")
+	if resp != "" {
+		t.Fatalf("response = %q, want none", resp)
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		Handler:        http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		MaxHeaderBytes: 1024,
+	})
+
+	raw := "GET / HTTP/1.1\r\nX-Large: " + strings.Repeat("a", 2048) + "\r\n\r\n"
+	resp := roundTrip(t, addr, raw)
+	if !strings.HasPrefix(resp, "HTTP/1.1 431 Request Header Fields Too Large\r\n") {
+		t.Fatalf("response = %q, want 431", resp)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	called := make(chan struct{}, 1)
+	addr := startTestServer(t, &Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called <- struct{}{}
+			body, err := io.ReadAll(r.Body)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			_, _ = w.Write(body)
+		}),
+		MaxBodyBytes: 5,
+	})
+
+	for _, tt := range []struct {
+		name       string
+		raw        string
+		wantPrefix string
+		wantCalled bool
+	}{
+		{
+			name:       "within the limit",
+			raw:        "POST / HTTP/1.1\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello",
+			wantPrefix: "HTTP/1.1 200 OK\r\n",
+			wantCalled: true,
+		},
+		{
+			name:       "content length too large",
+			raw:        "POST / HTTP/1.1\r\nContent-Length: 6\r\n\r\nhello!",
+			wantPrefix: "HTTP/1.1 413 Request Entity Too Large\r\n",
+		},
+		{
+			// The client never has to send the body.
+			name:       "content length too large with expect",
+			raw:        "POST / HTTP/1.1\r\nContent-Length: 6\r\nExpect: 100-continue\r\n\r\n",
+			wantPrefix: "HTTP/1.1 413 Request Entity Too Large\r\n",
+		},
+		{
+			name:       "chunked within the limit",
+			raw:        "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			wantPrefix: "HTTP/1.1 200 OK\r\n",
+			wantCalled: true,
+		},
+		{
+			name:       "chunked too large",
+			raw:        "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello!\r\n0\r\n\r\n",
+			wantPrefix: "HTTP/1.1 413 Request Entity Too Large\r\n",
+			wantCalled: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server closes the connection after a body that is too
+			// large, so roundTrip returns.
+			resp := roundTrip(t, addr, tt.raw)
+			if !strings.HasPrefix(resp, tt.wantPrefix) {
+				t.Fatalf("response = %q, want prefix %q", resp, tt.wantPrefix)
+			}
+			if tt.wantPrefix != "HTTP/1.1 200 OK\r\n" && !strings.Contains(resp, "Connection: close\r\n") {
+				t.Fatalf("response = %q, want Connection: close", resp)
+			}
+			if strings.Contains(resp, "100 Continue") {
+				t.Fatalf("response = %q, want no 100 Continue", resp)
+			}
+			select {
+			case <-called:
+				if !tt.wantCalled {
+					t.Fatal("handler was called")
+				}
+			default:
+				if tt.wantCalled {
+					t.Fatal("handler was not called")
+				}
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytesClosesConnection(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		// The handler doesn't read the body, so the server runs into the
+		// limit while draining it.
+		Handler:      http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		MaxBodyBytes: 5,
+	})
+
+	// A chunk that would be read as the next request if the server kept
+	// the connection open.
+	smuggled := "GET /smuggled HTTP/1.1\r\n\r\n"
+	raw := "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		fmt.Sprintf("%x\r\nhello!%s\r\n0\r\n\r\n", 6+len(smuggled), smuggled)
+	resp := roundTrip(t, addr, raw)
+	if n := strings.Count(resp, "HTTP/1.1 "); n != 1 {
+		t.Fatalf("got %d responses, want 1: %q", n, resp)
+	}
+}
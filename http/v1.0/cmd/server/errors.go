@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// requestError is a request we can't or won't handle. It is answered with
+// statusCode before the connection is closed, since we can't know where the
+// next request would start.
+type requestError struct {
+	statusCode int
+	// header holds extra response headers, like Allow for 405 responses.
+	header http.Header
+	err    error
+}
+
+func (e *requestError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.statusCode, http.StatusText(e.statusCode), e.err)
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+func badRequest(format string, args ...any) error {
+	return &requestError{statusCode: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+// errorStatusCode returns the status code the client was sent for err, or 0
+// if err is not a protocol error.
+func errorStatusCode(err error) int {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return reqErr.statusCode
+	}
+	return 0
+}
+
+// writeErrorResponse replies to a request that could not be read.
+func writeErrorResponse(conn net.Conn, reqErr *requestError) error {
+	// The write deadline may be left over from the previous request.
+	if err := conn.SetWriteDeadline(time.Time{}); err != nil {
+		return err
+	}
+	statusCode := reqErr.statusCode
+	body := fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
+	header := http.Header{
+		"Content-Type":   {"text/plain; charset=utf-8"},
+		"Content-Length": {fmt.Sprint(len(body))},
+		"Connection":     {"close"},
+	}
+	for k, vals := range reqErr.header {
+		header[k] = vals
+	}
+
+	if _, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode)); err != nil {
+		return err
+	}
+	if err := header.Write(conn); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "\r\n%s", body); err != nil {
+		return err
+	}
+	lingerClose(conn)
+	return nil
+}
+
+// lingerTimeout is how long lingerClose waits for the client to close its
+// side of the connection.
+const lingerTimeout = 500 * time.Millisecond
+
+// lingerClose half-closes conn and discards what the client is still
+// sending. Closing a socket with unread data makes the kernel send a reset,
+// which can destroy the response before the client has read it.
+func lingerClose(conn net.Conn) {
+	closeWrite(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	_, _ = io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// statusRecorder is a slog.Handler that records the status attribute of
+// logged errors.
+type statusRecorder struct {
+	statuses chan int64
+}
+
+func (h *statusRecorder) Enabled(context.Context, slog.Level) bool { return true }
+func (h *statusRecorder) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *statusRecorder) WithGroup(string) slog.Handler            { return h }
+
+func (h *statusRecorder) Handle(_ context.Context, r slog.Record) error {
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "status" {
+			h.statuses <- a.Value.Int64()
+		}
+		return true
+	})
+	return nil
+}
+
+func TestMalformedRequests(t *testing.T) {
+	rec := &statusRecorder{statuses: make(chan int64, 1)}
+	addr := startTestServer(t, &Server{
+		Handler:  http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		ErrorLog: slog.New(rec),
+	})
+
+	for _, tt := range []struct {
+		name       string
+		raw        string
+		wantStatus int
+		wantAllow  bool
+	}{
+		{
+			name:       "missing request target",
+			raw:        "GET\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid method characters",
+			raw:        "G(T / HTTP/1.1\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported method",
+			raw:        "PROPFIND / HTTP/1.1\r\n\r\n",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  true,
+		},
+		{
+			name:       "relative request target",
+			raw:        "GET foo HTTP/1.1\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "request target too long",
+			raw:        "GET /" + strings.Repeat("a", maxRequestURIBytes) + " HTTP/1.1\r\n\r\n",
+			wantStatus: http.StatusRequestURITooLong,
+		},
+		{
+			name:       "unknown protocol",
+			raw:        "GET / FTP/1.0\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported version",
+			raw:        "GET / HTTP/3.0\r\n\r\n",
+			wantStatus: http.StatusHTTPVersionNotSupported,
+		},
+		{
+			name:       "header without colon",
+			raw:        "GET / HTTP/1.1\r\nHost example.com\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid content length",
+			raw:        "POST / HTTP/1.1\r\nContent-Length: abc\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "negative content length",
+			raw:        "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported transfer encoding",
+			raw:        "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n",
+			wantStatus: http.StatusNotImplemented,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(roundTrip(t, addr, tt.raw))), nil)
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if !resp.Close {
+				t.Fatal("connection was not closed")
+			}
+			if got := resp.Header.Get("Allow"); tt.wantAllow != (got != "") {
+				t.Fatalf("Allow = %q, want present: %t", got, tt.wantAllow)
+			}
+			if got := <-rec.statuses; got != int64(tt.wantStatus) {
+				t.Fatalf("logged status = %d, want %d", got, tt.wantStatus)
+			}
+		})
+	}
+}
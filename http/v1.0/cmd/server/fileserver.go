@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRanges limits the ranges of a multipart/byteranges response, requests
+// asking for more get the whole file.
+const maxRanges = 100
+
+// errUnsatisfiableRange means none of the requested ranges overlap the file.
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// fileServer serves the files of root, like http.FileServer. Byte ranges
+// and conditional requests are handled here, and the body is copied with
+// io.Copy so our writer can hand files to the kernel (sendfile) instead of
+// passing them through user space.
+type fileServer struct {
+	root http.FileSystem
+}
+
+func newFileServer(root http.FileSystem) http.Handler {
+	return &fileServer{root: root}
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	f, err := s.root.Open(name)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	if info.IsDir() {
+		// Relative links in the index only work below the trailing slash.
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			u := url.URL{Path: path.Base(r.URL.Path) + "/", RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+		index, err := s.root.Open(path.Join(name, "index.html"))
+		if err != nil {
+			listDirectory(w, r, f)
+			return
+		}
+		defer func() { _ = index.Close() }()
+		if info, err = index.Stat(); err != nil {
+			fileError(w, err)
+			return
+		}
+		f = index
+	}
+	serveContent(w, r, info.Name(), info.ModTime(), info.Size(), f)
+}
+
+// serveContent answers r with size bytes of content, honoring conditional
+// and range requests.
+func serveContent(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, size int64, content io.ReadSeeker) {
+	h := w.Header()
+	etag := fileETag(modTime, size)
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	if notModified(r, etag, modTime) {
+		// The entity tag identifies the version, like net/http we leave out
+		// the date (RFC 9110 15.4.5).
+		h.Del("Last-Modified")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		var sample [sniffLen]byte
+		n, _ := io.ReadFull(content, sample[:])
+		contentType = http.DetectContentType(sample[:n])
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.Set("Content-Type", contentType)
+
+	var ranges []byteRange
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && ifRangeMatches(r, etag, modTime) {
+		var err error
+		ranges, err = parseRange(rangeHeader, size)
+		if err != nil {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		copyRange(w, r, content, byteRange{start: 0, length: size})
+	case 1:
+		h.Set("Content-Range", ranges[0].contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		copyRange(w, r, content, ranges[0])
+	default:
+		serveMultipartRanges(w, r, content, contentType, size, ranges)
+	}
+}
+
+// serveMultipartRanges sends several ranges as the parts of a
+// multipart/byteranges body (RFC 9110 14.6).
+func serveMultipartRanges(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, contentType string, size int64, ranges []byteRange) {
+	// Compute the length by writing the part headers without the data.
+	var counter countingWriter
+	mw := multipart.NewWriter(&counter)
+	for _, br := range ranges {
+		if _, err := mw.CreatePart(br.partHeader(contentType, size)); err != nil {
+			return
+		}
+		counter += countingWriter(br.length)
+	}
+	_ = mw.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	h.Set("Content-Length", strconv.FormatInt(int64(counter), 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	body := multipart.NewWriter(w)
+	if err := body.SetBoundary(mw.Boundary()); err != nil {
+		return
+	}
+	for _, br := range ranges {
+		part, err := body.CreatePart(br.partHeader(contentType, size))
+		if err != nil {
+			return
+		}
+		if _, err := content.Seek(br.start, io.SeekStart); err != nil {
+			return
+		}
+		if _, err := io.CopyN(part, content, br.length); err != nil {
+			return
+		}
+	}
+	_ = body.Close()
+}
+
+// copyRange writes br of content to w. io.CopyN lets w read the file itself
+// if it implements io.ReaderFrom.
+func copyRange(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, br byteRange) {
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := content.Seek(br.start, io.SeekStart); err != nil {
+		return
+	}
+	// The status is out, all we can do on errors is cut the body short.
+	_, _ = io.CopyN(w, content, br.length)
+}
+
+// fileETag derives a strong validator from the modification time and size,
+// like most servers do, rather than hashing the content.
+func fileETag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since in its absence,
+// for GET and HEAD (RFC 9110 13.2.2).
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// Dates only have a resolution of seconds.
+	return !modTime.Truncate(time.Second).After(since)
+}
+
+// etagListMatches reports whether the comma separated list of entity tags
+// contains etag, using the weak comparison (RFC 9110 8.8.3.2).
+func etagListMatches(list, etag string) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches reports whether the Range header applies. If-Range holds
+// either a strong entity tag or a date, which must match exactly, otherwise
+// the whole file is sent (RFC 9110 13.1.5).
+func ifRangeMatches(r *http.Request, etag string, modTime time.Time) bool {
+	ifRange := r.Header.Get("If-Range")
+	switch {
+	case ifRange == "":
+		return true
+	case strings.HasPrefix(ifRange, `"`), strings.HasPrefix(ifRange, "W/"):
+		return ifRange == etag && !strings.HasPrefix(etag, "W/")
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && modTime.Truncate(time.Second).Equal(date)
+}
+
+// byteRange is a satisfiable range of the file.
+type byteRange struct {
+	start, length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+func (br byteRange) partHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {br.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// parseRange parses the byte ranges of a Range header for a file of size
+// bytes (RFC 9110 14.1.2). Ranges past the end are dropped and the others
+// are clipped to the file, if none is left errUnsatisfiableRange is
+// returned. Malformed headers and other units are ignored like a missing
+// header, as are requests for more ranges or bytes than the file has.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(header, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, nil
+	}
+	var ranges []byteRange
+	specs := 0
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		specs++
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		if first == "" {
+			// A suffix range asks for the last bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n = min(n, size); n > 0 {
+				ranges = append(ranges, byteRange{start: size - n, length: n})
+			}
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < start {
+				return nil, nil
+			}
+			end = min(n, end)
+		}
+		if start < size {
+			ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+		}
+	}
+
+	if specs == 0 || specs > maxRanges {
+		return nil, nil
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	var total int64
+	for _, br := range ranges {
+		total += br.length
+	}
+	if total > size {
+		// Overlapping ranges would make us send more than the file.
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// listDirectory writes an HTML list of the entries of dir.
+func listDirectory(w http.ResponseWriter, r *http.Request, dir http.File) {
+	entries, err := dir.Readdir(-1)
+	if err != nil {
+		http.Error(w, "Error reading directory", http.StatusInternalServerError)
+		return
+	}
+	slices.SortFunc(entries, func(a, b fs.FileInfo) int { return strings.Compare(a.Name(), b.Name()) })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		u := url.URL{Path: name}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", html.EscapeString(u.String()), html.EscapeString(name))
+	}
+	b.WriteString("</pre>\n")
+	_, _ = io.WriteString(w, b.String())
+}
+
+// fileError answers with the status that matches an error opening a file,
+// without revealing the error itself.
+func fileError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
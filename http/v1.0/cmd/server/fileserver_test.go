@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, tt := range []struct {
+		header  string
+		want    []byteRange
+		wantErr error
+	}{
+		{header: "bytes=0-4", want: []byteRange{{0, 5}}},
+		{header: "bytes=5-", want: []byteRange{{5, 5}}},
+		{header: "bytes=-3", want: []byteRange{{7, 3}}},
+		{header: "bytes=-20", want: []byteRange{{0, 10}}},
+		{header: "bytes=8-20", want: []byteRange{{8, 2}}},
+		{header: "bytes= 0-1 , 4-5", want: []byteRange{{0, 2}, {4, 2}}},
+		{header: "bytes=0-1,20-30", want: []byteRange{{0, 2}}},
+		{header: "bytes=10-", wantErr: errUnsatisfiableRange},
+		{header: "bytes=-0", wantErr: errUnsatisfiableRange},
+		{header: "bytes=5-4"},
+		{header: "bytes=a-b"},
+		{header: "bytes=1"},
+		{header: "bytes="},
+		{header: "lines=0-4"},
+		// Overlapping ranges add up to more than the file.
+		{header: "bytes=0-9,0-9"},
+	} {
+		got, err := parseRange(tt.header, 10)
+		if !reflect.DeepEqual(got, tt.want) || err != tt.wantErr {
+			t.Errorf("parseRange(%q) = %v, %v, want %v, %v", tt.header, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	public := t.TempDir()
+	// Large enough to be sent with ReadFrom rather than buffered.
+	content := bytes.Repeat([]byte("0123456789"), 100_000)
+	if err := os.WriteFile(filepath.Join(public, "data.txt"), content, 0o600); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(public, "dir"), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	addr := "http://" + startServer(t, accessLog(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, compress(newMux(public))))
+
+	// Without Accept-Encoding the body passes through compress untouched.
+	transport := &http.Transport{DisableCompression: true}
+	t.Cleanup(transport.CloseIdleConnections)
+	get := func(t *testing.T, method, path string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, addr+path, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		if header != nil {
+			req.Header = header
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp, readBody(t, resp)
+	}
+
+	resp, body := get(t, http.MethodGet, "/data.txt", nil)
+	if resp.StatusCode != http.StatusOK || body != string(content) {
+		t.Fatalf("GET = %d with %d bytes, want 200 with %d bytes", resp.StatusCode, len(body), len(content))
+	}
+	if resp.ContentLength != int64(len(content)) || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("Content-Length = %d, Accept-Ranges = %q, want %d and bytes",
+			resp.ContentLength, resp.Header.Get("Accept-Ranges"), len(content))
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("ETag = %q, Last-Modified = %q, want both set", etag, lastModified)
+	}
+
+	t.Run("HEAD", func(t *testing.T) {
+		resp, body := get(t, http.MethodHead, "/data.txt", nil)
+		if resp.StatusCode != http.StatusOK || body != "" || resp.ContentLength != int64(len(content)) {
+			t.Fatalf("HEAD = %d %q with Content-Length %d, want 200 without body", resp.StatusCode, body, resp.ContentLength)
+		}
+	})
+
+	t.Run("single range", func(t *testing.T) {
+		resp, body := get(t, http.MethodGet, "/data.txt", http.Header{"Range": {"bytes=12-15"}})
+		wantRange := "bytes 12-15/" + strconv.Itoa(len(content))
+		if resp.StatusCode != http.StatusPartialContent || body != "2345" || resp.Header.Get("Content-Range") != wantRange {
+			t.Fatalf("response = %d %q %q, want 206 %q %q",
+				resp.StatusCode, resp.Header.Get("Content-Range"), body, wantRange, "2345")
+		}
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		resp, body := get(t, http.MethodGet, "/data.txt", http.Header{"Range": {"bytes=0-1,-2"}})
+		if resp.StatusCode != http.StatusPartialContent || resp.ContentLength != int64(len(body)) {
+			t.Fatalf("response = %d with Content-Length %d and %d bytes, want 206 with matching length",
+				resp.StatusCode, resp.ContentLength, len(body))
+		}
+		mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Content-Type = %q, want multipart/byteranges", resp.Header.Get("Content-Type"))
+		}
+		mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+		size := strconv.Itoa(len(content))
+		for _, want := range []struct{ contentRange, body string }{
+			{"bytes 0-1/" + size, "01"},
+			{"bytes 999998-999999/" + size, "89"},
+		} {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("next part: %v", err)
+			}
+			body, _ := io.ReadAll(part)
+			if got := part.Header.Get("Content-Range"); got != want.contentRange || string(body) != want.body {
+				t.Fatalf("part = %q %q, want %q %q", got, body, want.contentRange, want.body)
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Fatalf("NextPart after the last part = %v, want EOF", err)
+		}
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		resp, _ := get(t, http.MethodGet, "/data.txt", http.Header{"Range": {"bytes=2000000-"}})
+		want := "bytes */" + strconv.Itoa(len(content))
+		if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || resp.Header.Get("Content-Range") != want {
+			t.Fatalf("response = %d %q, want 416 %q", resp.StatusCode, resp.Header.Get("Content-Range"), want)
+		}
+	})
+
+	t.Run("If-Range", func(t *testing.T) {
+		for _, tt := range []struct {
+			ifRange    string
+			wantStatus int
+		}{
+			{ifRange: etag, wantStatus: http.StatusPartialContent},
+			{ifRange: lastModified, wantStatus: http.StatusPartialContent},
+			{ifRange: `"stale"`, wantStatus: http.StatusOK},
+			{ifRange: "W/" + etag, wantStatus: http.StatusOK},
+			{ifRange: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), wantStatus: http.StatusOK},
+		} {
+			resp, _ := get(t, http.MethodGet, "/data.txt", http.Header{"Range": {"bytes=0-0"}, "If-Range": {tt.ifRange}})
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("If-Range %q: status = %d, want %d", tt.ifRange, resp.StatusCode, tt.wantStatus)
+			}
+		}
+	})
+
+	t.Run("conditional", func(t *testing.T) {
+		for _, tt := range []struct {
+			name       string
+			header     http.Header
+			wantStatus int
+		}{
+			{name: "matching ETag", header: http.Header{"If-None-Match": {`"other", ` + etag}}, wantStatus: http.StatusNotModified},
+			{name: "weak ETag", header: http.Header{"If-None-Match": {"W/" + etag}}, wantStatus: http.StatusNotModified},
+			{name: "any ETag", header: http.Header{"If-None-Match": {"*"}}, wantStatus: http.StatusNotModified},
+			{name: "other ETag", header: http.Header{"If-None-Match": {`"other"`}}, wantStatus: http.StatusOK},
+			{name: "not modified since", header: http.Header{"If-Modified-Since": {lastModified}}, wantStatus: http.StatusNotModified},
+			{
+				name:       "modified since",
+				header:     http.Header{"If-Modified-Since": {time.Unix(0, 0).UTC().Format(http.TimeFormat)}},
+				wantStatus: http.StatusOK,
+			},
+			{
+				// If-None-Match takes precedence.
+				name:       "other ETag not modified since",
+				header:     http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {lastModified}},
+				wantStatus: http.StatusOK,
+			},
+		} {
+			resp, body := get(t, http.MethodGet, "/data.txt", tt.header)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.wantStatus)
+			}
+			if resp.StatusCode == http.StatusNotModified && (body != "" || resp.Header.Get("ETag") != etag) {
+				t.Errorf("%s: 304 with body %q and ETag %q, want no body and %q", tt.name, body, resp.Header.Get("ETag"), etag)
+			}
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		resp, _ := get(t, http.MethodGet, "/dir?x=1", nil)
+		if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/dir/?x=1" {
+			t.Fatalf("response = %d %q, want 301 to %q", resp.StatusCode, resp.Header.Get("Location"), "/dir/?x=1")
+		}
+		resp, body := get(t, http.MethodGet, "/", nil)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, `<a href="data.txt">data.txt</a>`) {
+			t.Fatalf("listing = %d %q, want 200 with a link to data.txt", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		resp, _ := get(t, http.MethodPost, "/data.txt", nil)
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, HEAD" {
+			t.Fatalf("response = %d with Allow %q, want 405 with GET, HEAD", resp.StatusCode, resp.Header.Get("Allow"))
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/http2"
+)
+
+// hasH2CPreface reports whether the client starts the connection with the
+// HTTP/2 preface. It peeks one more byte at a time while they match, so an
+// HTTP/1.x request shorter than the preface doesn't block.
+func hasH2CPreface(reader *bufio.Reader) bool {
+	for n := 1; n <= len(http2.ClientPreface); n++ {
+		b, err := reader.Peek(n)
+		if err != nil || !strings.HasPrefix(http2.ClientPreface, string(b)) {
+			return false
+		}
+	}
+	return true
+}
+
+// serveH2C serves a connection that starts with the HTTP/2 preface, from
+// reader which still holds it.
+func (s *Server) serveH2C(handler http.Handler, conn net.Conn, reader io.Reader) error {
+	// Streams are multiplexed on the connection, its deadlines can't
+	// apply to a single request.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	h2 := &http2.Server{
+		MaxHeaderBytes: int(s.maxHeaderBytes()),
+		IdleTimeout:    s.idleTimeout(),
+	}
+	return h2.ServeConn(s.baseContext(), conn, reader, s.h2Handler(handler, conn))
+}
+
+// h2Handler applies the limits that are checked before calling the handler
+// of an HTTP/1.x request to every stream.
+func (s *Server) h2Handler(handler http.Handler, conn net.Conn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ok, wait := s.allowRequest(conn); !ok {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		if s.MaxBodyBytes > 0 {
+			if req.ContentLength > s.MaxBodyBytes {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			req.Body = http.MaxBytesReader(w, req.Body, s.MaxBodyBytes)
+		}
+		if req.RequestURI == "*" && !s.DisableGeneralOptionsHandler {
+			generalOptionsHandler(w, req)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
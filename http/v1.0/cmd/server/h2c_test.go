@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+// newH2CClient returns a client speaking HTTP/2 over cleartext TCP with prior
+// knowledge.
+func newH2CClient(t *testing.T) *http.Client {
+	t.Helper()
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}
+}
+
+func TestH2C(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, r.Proto+" "+string(body))
+	})
+	addr := startTestServer(t, &Server{Handler: handler, H2C: true, MaxBodyBytes: 8})
+	h2c := newH2CClient(t)
+
+	resp, err := h2c.Post("http://"+addr, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("h2c post: %v", err)
+	}
+	if body := readBody(t, resp); body != "HTTP/2.0 hello" {
+		t.Fatalf("h2c body = %q, want %q", body, "HTTP/2.0 hello")
+	}
+
+	resp, err = h2c.Post("http://"+addr, "text/plain", strings.NewReader("too large for the limit"))
+	if err != nil {
+		t.Fatalf("h2c post: %v", err)
+	}
+	readBody(t, resp)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413 from MaxBodyBytes", resp.StatusCode)
+	}
+
+	// HTTP/1.1 clients are still served on the same port.
+	resp, err = http.Post("http://"+addr, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("HTTP/1.1 post: %v", err)
+	}
+	if body := readBody(t, resp); body != "HTTP/1.1 hello" {
+		t.Fatalf("HTTP/1.1 body = %q, want %q", body, "HTTP/1.1 hello")
+	}
+}
+
+func TestH2CDisabled(t *testing.T) {
+	addr := startServer(t, http.NotFoundHandler())
+	if _, err := newH2CClient(t).Get("http://" + addr); err == nil {
+		t.Fatal("h2c request succeeded on a server without H2C")
+	}
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return string(body)
+}
@@ -1,285 +1,89 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"errors"
-	"fmt"
-	"io"
+	"flag"
 	"log"
 	"log/slog"
-	"math"
-	"net"
 	"net/http"
-	"net/textproto"
-	"net/url"
-	"strconv"
-	"strings"
+	"os"
+	"os/signal"
+	"time"
 )
 
-type Server struct {
-	Addr    string
-	Handler http.Handler
-}
-
-func (s *Server) ServeAndListen() error {
-	handler := s.Handler
-	if handler == nil {
-		handler = http.DefaultServeMux
-	}
-	var lc net.ListenConfig
-	l, err := lc.Listen(context.Background(), "tcp", s.Addr)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = l.Close() }()
+func main() {
+	useTLS := flag.Bool("tls", false, "serve HTTPS with an in-memory self-signed certificate")
+	proxy := flag.Bool("proxy", false, "also act as a forward proxy, including CONNECT tunnels")
+	adminAddr := flag.String("admin", "127.0.0.1:9001", "address of the admin server exposing /metrics")
+	flag.Parse()
 
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			return err
+	addr := "127.0.0.1:9000"
+	mux := newMux("public")
+	handler := compress(mux)
+	if *proxy {
+		// Forwarded responses keep the upstream encoding.
+		handler = newForwardProxy(handler)
+	}
+
+	m := newMetrics()
+	s := &Server{
+		Addr:              addr,
+		Handler:           accessLog(slog.Default(), m, handler),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       time.Minute,
+		MaxBodyBytes:      10 << 20,
+		MaxConns:          1000,
+		RateLimit:         100,
+		RateBurst:         200,
+		H2C:               true,
+	}
+
+	// Metrics are served on a separate address so they can be kept private.
+	adminMux := http.NewServeMux()
+	adminMux.Handle("GET /metrics", m)
+	admin := &Server{
+		Addr:              *adminAddr,
+		Handler:           adminMux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("Starting admin server: http://%s/metrics", *adminAddr)
+		if err := admin.ServeAndListen(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("admin server failed", "error", err)
 		}
-
-		go func() {
-			if err := s.handleConnection(handler, conn); err != nil {
-				slog.Error(fmt.Sprintf("http error: %s", err))
-			}
-		}()
-	}
-}
-
-func (s *Server) handleConnection(handler http.Handler, conn net.Conn) error {
-	defer func() { _ = conn.Close() }()
-
-	// Limit headers to 1MB
-	limitReader := io.LimitReader(conn, 1*1024*1024).(*io.LimitedReader)
-	reader := bufio.NewReader(limitReader)
-	headerReader := textproto.NewReader(bufio.NewReader(reader))
-
-	// Read the request line: GET /path/to/index.html HTTP/1.0
-	reqLine, err := headerReader.ReadLine()
-	if err != nil {
-		return fmt.Errorf("read request line error: %w", err)
-	}
-
-	req := new(http.Request)
-	var found bool
-
-	// Parse Method: GET/POST/PUT/DELETE/etc
-	req.Method, reqLine, found = strings.Cut(reqLine, " ")
-	if !found {
-		return errors.New("invalid method")
-	}
-	if !methodValid(req.Method) {
-		return errors.New("invalid method")
-	}
-
-	// Parse Request URI
-	req.RequestURI, reqLine, found = strings.Cut(reqLine, " ")
-	if !found {
-		return errors.New("invalid path")
-	}
-	if req.URL, err = url.ParseRequestURI(req.RequestURI); err != nil {
-		return fmt.Errorf("invalid path: %w", err)
-	}
-
-	// Parse protocol version "HTTP/1.0"
-	req.Proto = reqLine
-	req.ProtoMajor, req.ProtoMinor, found = parseProtocol(req.Proto)
-	if !found {
-		return errors.New("invalid proto")
-	}
-
-	// Parse headers
-	req.Header = make(http.Header)
-	for {
-		line, err := headerReader.ReadLineBytes()
-		if err != nil && !errors.Is(err, io.EOF) {
-			return err
-		} else if err != nil {
-			break
+	}()
+
+	// Drain in-flight requests on Ctrl+C instead of dropping them.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop() // a second Ctrl+C kills the process
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			slog.Error("shutdown failed", "error", err)
 		}
-		if len(line) == 0 {
-			break
+		if err := admin.Shutdown(shutdownCtx); err != nil {
+			slog.Error("admin shutdown failed", "error", err)
 		}
+	}()
 
-		k, v, ok := bytes.Cut(line, []byte{':'})
-		if !ok {
-			return errors.New("invalid header")
+	var err error
+	if *useTLS {
+		cert, certErr := selfSignedCertificate("localhost", "127.0.0.1")
+		if certErr != nil {
+			log.Fatal(certErr)
 		}
-		req.Header.Add(strings.ToLower(string(k)), strings.TrimLeft(string(v), " "))
-	}
-
-	// Unbound the limit after we've read the headers since the body can be any size
-	limitReader.N = math.MaxInt64
-
-	ctx := context.Background()
-	ctx = context.WithValue(ctx, http.LocalAddrContextKey, conn.LocalAddr())
-	ctx, cancelCtx := context.WithCancel(ctx)
-	defer cancelCtx()
-	contentLength, err := parseContentLength(req.Header.Get("Content-Length"))
-	if err != nil {
-		return err
-	}
-	req.ContentLength = contentLength
-	if req.ContentLength == 0 {
-		req.Body = noBody{}
+		s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		log.Printf("Starting web server: https://%s", addr)
+		err = s.ListenAndServeTLS("", "")
 	} else {
-		req.Body = &bodyReader{reader: io.LimitReader(reader, req.ContentLength)}
-	}
-
-	req.RemoteAddr = conn.RemoteAddr().String()
-	req.Close = true // this is always true for HTTP/1.0
-
-	w := &responseBodyWriter{
-		// We hard-code this because this is a HTTP/1.0 server.
-		// Web servers will make requests with HTTP/1.1 but
-		// we're saying that we only support HTTP/1.0.
-		proto:   "HTTP/1.0",
-		conn:    conn,
-		headers: make(http.Header),
-	}
-
-	// Finally, call our http.Handler!
-	handler.ServeHTTP(w, req.WithContext(ctx))
-	if !w.sentHeaders {
-		return w.sendHeaders(http.StatusOK)
-	}
-	return nil
-}
-
-type noBody struct{}
-
-func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
-func (noBody) Close() error             { return nil }
-
-type bodyReader struct {
-	reader io.Reader
-}
-
-func (r *bodyReader) Read(p []byte) (n int, err error) {
-	return r.reader.Read(p)
-}
-
-func (r *bodyReader) Close() error {
-	_, err := io.Copy(io.Discard, r.reader)
-	return err
-}
-
-func parseContentLength(headerval string) (int64, error) {
-	if headerval == "" {
-		return 0, nil
-	}
-
-	return strconv.ParseInt(headerval, 10, 64)
-}
-
-func parseProtocol(proto string) (int, int, bool) {
-	switch proto {
-	case "HTTP/1.0":
-		return 1, 0, true
-	case "HTTP/1.1":
-		return 1, 1, true
-	}
-	return 0, 0, false
-}
-
-func methodValid(method string) bool {
-	switch method {
-	case http.MethodGet,
-		http.MethodHead,
-		http.MethodPost,
-		http.MethodPut,
-		http.MethodPatch,
-		http.MethodDelete,
-		http.MethodConnect,
-		http.MethodOptions,
-		http.MethodTrace:
-		return true
-	}
-	return false
-}
-
-type responseBodyWriter struct {
-	proto       string
-	conn        net.Conn
-	sentHeaders bool
-	headers     http.Header
-}
-
-func (r *responseBodyWriter) Header() http.Header {
-	return r.headers
-}
-
-func (r *responseBodyWriter) Write(b []byte) (int, error) {
-	if !r.sentHeaders {
-		if err := r.sendHeaders(http.StatusOK); err != nil {
-			return 0, err
-		}
-	}
-	return r.conn.Write(b)
-}
-
-func (r *responseBodyWriter) WriteHeader(statusCode int) {
-	if r.sentHeaders {
-		slog.Warn(fmt.Sprintf("WriteHeader called twice, second time with: %d", statusCode))
-		return
-	}
-	if err := r.sendHeaders(statusCode); err != nil {
-		slog.Error("failed to send headers", "error", err)
-	}
-}
-
-func (r *responseBodyWriter) sendHeaders(statusCode int) error {
-	r.sentHeaders = true
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "%s %d %s\r\n", r.proto, statusCode, http.StatusText(statusCode))
-	for k, vals := range r.headers {
-		for _, val := range vals {
-			fmt.Fprintf(&buf, "%s: %s\r\n", k, val)
-		}
-	}
-	fmt.Fprint(&buf, "\r\n")
-	_, err := r.conn.Write(buf.Bytes())
-	return err
-}
-
-func main() {
-	addr := "127.0.0.1:9000"
-	mux := http.NewServeMux()
-	mux.Handle("/", http.FileServer(http.Dir("public")))
-	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
-		defer func() { _ = r.Body.Close() }()
-		if _, err := io.Copy(w, r.Body); err != nil {
-			slog.Error("echo copy failed", "error", err)
-		}
-	})
-	mux.HandleFunc("/status/{status}", func(w http.ResponseWriter, r *http.Request) {
-		status, err := strconv.ParseInt(r.PathValue("status"), 10, 64)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			if _, err := fmt.Fprintf(w, "error: %s", err); err != nil {
-				slog.Error("failed to write error response", "error", err)
-			}
-			return
-		}
-		w.WriteHeader(int(status))
-	})
-	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("content-type", "application/json")
-		if err := json.NewEncoder(w).Encode(r.Header); err != nil {
-			slog.Error("headers encode failed", "error", err)
-		}
-	})
-	mux.HandleFunc("/nothing", func(_ http.ResponseWriter, _ *http.Request) {})
-	s := Server{
-		Addr:    addr,
-		Handler: mux,
+		log.Printf("Starting web server: http://%s", addr)
+		err = s.ServeAndListen()
 	}
-	log.Printf("Starting web server: http://%s", addr)
-	if err := s.ServeAndListen(); err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
 }
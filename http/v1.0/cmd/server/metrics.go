@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// durationBuckets are the upper bounds of the request duration
+	// histogram, in seconds.
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// sizeBuckets are the upper bounds of the response size histogram, in
+	// bytes.
+	sizeBuckets = []float64{100, 1000, 10_000, 100_000, 1_000_000, 10_000_000}
+)
+
+// metrics collects request counters and histograms and serves them in the
+// Prometheus text exposition format.
+type metrics struct {
+	mu       sync.Mutex
+	inFlight int64
+	requests map[requestLabels]uint64
+	duration map[string]*histogram
+	size     map[string]*histogram
+}
+
+type requestLabels struct {
+	method string
+	code   int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[requestLabels]uint64),
+		duration: make(map[string]*histogram),
+		size:     make(map[string]*histogram),
+	}
+}
+
+func (m *metrics) requestStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight++
+}
+
+func (m *metrics) requestFinished() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+}
+
+// observe records a finished request.
+func (m *metrics) observe(method string, code int, size int64, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestLabels{method: method, code: code}]++
+	if m.duration[method] == nil {
+		m.duration[method] = newHistogram(durationBuckets)
+		m.size[method] = newHistogram(sizeBuckets)
+	}
+	m.duration[method].observe(duration.Seconds())
+	m.size[method].observe(float64(size))
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		slog.Error("failed to write metrics", "error", err)
+	}
+}
+
+// writeTo writes the metrics in the Prometheus text format, with series
+// sorted so the output is stable.
+func (m *metrics) writeTo(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buf.WriteString("# HELP http_requests_in_flight Requests currently being served.\n")
+	buf.WriteString("# TYPE http_requests_in_flight gauge\n")
+	fmt.Fprintf(buf, "http_requests_in_flight %d\n", m.inFlight)
+
+	buf.WriteString("# HELP http_requests_total Requests served, by method and status code.\n")
+	buf.WriteString("# TYPE http_requests_total counter\n")
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	slices.SortFunc(labels, func(a, b requestLabels) int {
+		return cmp.Or(cmp.Compare(a.method, b.method), cmp.Compare(a.code, b.code))
+	})
+	for _, l := range labels {
+		fmt.Fprintf(buf, "http_requests_total{method=%q,code=\"%d\"} %d\n", l.method, l.code, m.requests[l])
+	}
+
+	writeHistograms(buf, "http_request_duration_seconds", "Time spent serving requests, by method.", m.duration)
+	writeHistograms(buf, "http_response_size_bytes", "Size of response bodies, by method.", m.size)
+}
+
+func writeHistograms(buf *bytes.Buffer, name, help string, hs map[string]*histogram) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+	methods := make([]string, 0, len(hs))
+	for method := range hs {
+		methods = append(methods, method)
+	}
+	slices.Sort(methods)
+	for _, method := range methods {
+		h := hs[method]
+		// Prometheus buckets are cumulative.
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(buf, "%s_bucket{method=%q,le=%q} %d\n", name, method, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket{method=%q,le=\"+Inf\"} %d\n", name, method, h.count)
+		fmt.Fprintf(buf, "%s_sum{method=%q} %s\n", name, method, formatFloat(h.sum))
+		fmt.Fprintf(buf, "%s_count{method=%q} %d\n", name, method, h.count)
+	}
+}
+
+// histogram counts observations in buckets with the given upper bounds.
+// counts[i] holds the observations that fall in bucket i only, the last
+// count is for observations above every bound.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i, _ := slices.BinarySearch(h.buckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics()
+	m.observe("GET", 200, 50, 20*time.Millisecond)
+	m.observe("GET", 200, 5000, 2*time.Second)
+	m.observe("GET", 404, 0, time.Millisecond)
+	m.observe("POST", 201, 100, 20*time.Second)
+	m.requestStarted()
+
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+	got := buf.String()
+
+	for _, want := range []string{
+		"# TYPE http_requests_in_flight gauge\nhttp_requests_in_flight 1\n",
+		"# TYPE http_requests_total counter\n" +
+			"http_requests_total{method=\"GET\",code=\"200\"} 2\n" +
+			"http_requests_total{method=\"GET\",code=\"404\"} 1\n" +
+			"http_requests_total{method=\"POST\",code=\"201\"} 1\n",
+		"# TYPE http_request_duration_seconds histogram\n",
+		"http_request_duration_seconds_bucket{method=\"GET\",le=\"0.005\"} 1\n",
+		"http_request_duration_seconds_bucket{method=\"GET\",le=\"0.025\"} 2\n",
+		"http_request_duration_seconds_bucket{method=\"GET\",le=\"2.5\"} 3\n",
+		"http_request_duration_seconds_bucket{method=\"POST\",le=\"10\"} 0\n",
+		"http_request_duration_seconds_bucket{method=\"POST\",le=\"+Inf\"} 1\n",
+		"http_request_duration_seconds_count{method=\"GET\"} 3\n",
+		"http_request_duration_seconds_sum{method=\"POST\"} 20\n",
+		// Bounds are inclusive.
+		"http_response_size_bytes_bucket{method=\"POST\",le=\"100\"} 1\n",
+		"http_response_size_bytes_bucket{method=\"GET\",le=\"1000\"} 2\n",
+		"http_response_size_bytes_sum{method=\"GET\"} 5050\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	m := newMetrics()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := accessLog(logger, m, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "nope", http.StatusTeapot)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/", nil))
+
+	addr := startServer(t, m)
+	resp := roundTrip(t, addr, "GET /metrics HTTP/1.0\r\n\r\n")
+	if !strings.Contains(resp, "Content-Type: text/plain; version=0.0.4; charset=utf-8\r\n") {
+		t.Errorf("response has no Prometheus content type:\n%s", resp)
+	}
+	for _, want := range []string{
+		"http_requests_in_flight 0\n",
+		"http_requests_total{method=\"DELETE\",code=\"418\"} 1\n",
+		"http_response_size_bytes_sum{method=\"DELETE\"} 5\n",
+	} {
+		if !strings.Contains(resp, want) {
+			t.Errorf("response does not contain %q:\n%s", want, resp)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// tunnelDialTimeout limits how long a CONNECT request waits for the
+// upstream connection.
+const tunnelDialTimeout = 10 * time.Second
+
+// forwardProxy serves requests in absolute-form by forwarding them, and
+// CONNECT requests by tunnelling. Other requests go to next.
+type forwardProxy struct {
+	next    http.Handler
+	forward *httputil.ReverseProxy
+	dialer  net.Dialer
+}
+
+func newForwardProxy(next http.Handler) *forwardProxy {
+	return &forwardProxy{
+		next: next,
+		forward: &httputil.ReverseProxy{
+			// The outgoing URL already is the absolute target. Hop-by-hop
+			// headers like Proxy-Connection are removed by ReverseProxy.
+			Rewrite: func(*httputil.ProxyRequest) {},
+		},
+	}
+}
+
+func (p *forwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodConnect:
+		p.tunnel(w, r)
+	case r.URL.IsAbs():
+		p.forward.ServeHTTP(w, r)
+	default:
+		p.next.ServeHTTP(w, r)
+	}
+}
+
+// tunnel connects to the authority in r and copies bytes in both directions
+// until both sides are done (RFC 9110 9.3.6).
+func (p *forwardProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), tunnelDialTimeout)
+	defer cancel()
+	upstream, err := p.dialer.DialContext(ctx, "tcp", r.URL.Host)
+	if err != nil {
+		http.Error(w, "proxy: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+
+	// Send the 200 before hijacking, the response writer leaves out the
+	// framing headers for a tunnel.
+	w.WriteHeader(http.StatusOK)
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		slog.Error("proxy: hijack failed", "error", err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Tear down the tunnel when the server shuts down.
+	stop := context.AfterFunc(r.Context(), func() {
+		_ = conn.Close()
+		_ = upstream.Close()
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The client may have sent data right after the request, it is
+		// buffered in brw.
+		_, _ = io.Copy(upstream, brw.Reader)
+		closeWrite(upstream)
+	}()
+	_, _ = io.Copy(conn, upstream)
+	closeWrite(conn)
+	<-done
+}
+
+// closeWrite half-closes conn, so the peer sees EOF while data may still
+// flow the other way.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestForwardProxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "origin "+r.URL.RequestURI())
+	}))
+	defer origin.Close()
+	tlsOrigin := httptest.NewTLSServer(origin.Config.Handler)
+	defer tlsOrigin.Close()
+
+	proxyURL := &url.URL{Scheme: "http", Host: startServer(t, newForwardProxy(http.NotFoundHandler()))}
+	transport := tlsOrigin.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client := &http.Client{Transport: transport}
+	defer transport.CloseIdleConnections()
+
+	// Plain HTTP is forwarded from absolute-form requests, HTTPS is
+	// tunnelled through CONNECT.
+	for _, target := range []string{origin.URL, tlsOrigin.URL} {
+		resp, err := client.Get(target + "/a?b=c")
+		if err != nil {
+			t.Fatalf("get %s: %v", target, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		if want := "origin /a?b=c"; string(body) != want {
+			t.Fatalf("body = %q, want %q", body, want)
+		}
+	}
+}
+
+func TestConnectTunnel(t *testing.T) {
+	var lc net.ListenConfig
+	upstream, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() { _ = upstream.Close() }()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	addr := startServer(t, newForwardProxy(http.NotFoundHandler()))
+	target := upstream.Addr().String()
+	// The data after the request must reach the upstream even though the
+	// server read it along with the request.
+	conn := dialAndSend(t, addr, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\nhello")
+	closeWrite(conn)
+	resp := readAll(t, conn)
+
+	head, body, _ := strings.Cut(resp, "\r\n\r\n")
+	if !strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("response = %q, want 200", resp)
+	}
+	for _, h := range []string{"Content-Length", "Transfer-Encoding", "Connection"} {
+		if strings.Contains(head, h+":") {
+			t.Errorf("response to CONNECT has a %s header: %q", h, head)
+		}
+	}
+	if body != "hello" {
+		t.Fatalf("tunnelled = %q, want %q", body, "hello")
+	}
+}
+
+func TestConnectTunnelUnreachable(t *testing.T) {
+	var lc net.ListenConfig
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	target := l.Addr().String()
+	_ = l.Close()
+
+	addr := startServer(t, newForwardProxy(http.NotFoundHandler()))
+	resp := roundTrip(t, addr, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n") {
+		t.Fatalf("response = %q, want 502", resp)
+	}
+}
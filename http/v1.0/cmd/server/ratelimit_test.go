@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Unix(1000, 0)
+
+	for _, tt := range []struct {
+		key      string
+		at       time.Duration
+		wantOK   bool
+		wantWait time.Duration
+	}{
+		// The burst is available right away.
+		{key: "a", wantOK: true},
+		{key: "a", wantOK: true},
+		{key: "a", wantOK: true},
+		{key: "a", wantOK: false, wantWait: 500 * time.Millisecond},
+		// Other clients have their own bucket.
+		{key: "b", wantOK: true},
+		// Tokens refill at 2 per second.
+		{key: "a", at: 250 * time.Millisecond, wantOK: false, wantWait: 250 * time.Millisecond},
+		{key: "a", at: 500 * time.Millisecond, wantOK: true},
+		{key: "a", at: 500 * time.Millisecond, wantOK: false, wantWait: 500 * time.Millisecond},
+		// But never beyond the burst.
+		{key: "a", at: time.Hour, wantOK: true},
+		{key: "a", at: time.Hour, wantOK: true},
+		{key: "a", at: time.Hour, wantOK: true},
+		{key: "a", at: time.Hour, wantOK: false, wantWait: 500 * time.Millisecond},
+	} {
+		ok, wait := l.allow(tt.key, now.Add(tt.at))
+		if ok != tt.wantOK || wait != tt.wantWait {
+			t.Fatalf("allow(%q) at %v = %v, %v, want %v, %v", tt.key, tt.at, ok, wait, tt.wantOK, tt.wantWait)
+		}
+	}
+
+	// "b" filled up again long ago and is dropped, "a" is still empty.
+	l.sweep(now.Add(time.Hour))
+	if _, ok := l.buckets["b"]; ok {
+		t.Fatalf("full bucket was not swept")
+	}
+	if _, ok := l.buckets["a"]; !ok {
+		t.Fatalf("empty bucket was swept")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, "ok")
+		}),
+		RateLimit: 0.01,
+		RateBurst: 2,
+	})
+
+	// The burst allows two requests, on one connection or several.
+	conn := dialAndSend(t, addr, "GET / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n")
+	br := bufio.NewReader(conn)
+	for range 2 {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(roundTrip(t, addr, "GET / HTTP/1.1\r\n\r\n"))), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	// One token takes 100 seconds at 0.01 per second.
+	if got := resp.Header.Get("Retry-After"); got != "100" {
+		t.Fatalf("Retry-After = %q, want %q", got, "100")
+	}
+	if !resp.Close {
+		t.Fatalf("connection not closed after 503")
+	}
+}
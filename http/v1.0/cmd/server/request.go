@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/chunked"
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+// maxRequestURIBytes limits the length of the request target. Anything longer
+// is answered with 414 URI Too Long.
+const maxRequestURIBytes = 8 * 1024
+
+// supportedMethods are the methods we know how to handle, sent in the Allow
+// header when a client uses any other method.
+var supportedMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// readRequest reads a single request line, its headers and sets up the body
+// reader. It returns io.EOF if the connection was closed before the first
+// byte of the request line. Malformed or unsupported requests are reported
+// as a *requestError.
+func readRequest(reader *bufio.Reader) (*http.Request, error) {
+	headerReader := textproto.NewReader(reader)
+
+	// Read the request line: GET /path/to/index.html HTTP/1.0
+	reqLine, err := headerReader.ReadLine()
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read request line error: %w", err)
+	}
+
+	req := new(http.Request)
+	var found bool
+
+	// Parse Method: GET/POST/PUT/DELETE/etc
+	req.Method, reqLine, found = strings.Cut(reqLine, " ")
+	if !found || !http1.ValidToken(req.Method) {
+		return nil, badRequest("invalid method %q", req.Method)
+	}
+	if !methodValid(req.Method) {
+		return nil, &requestError{
+			statusCode: http.StatusMethodNotAllowed,
+			header:     http.Header{"Allow": {strings.Join(supportedMethods, ", ")}},
+			err:        fmt.Errorf("unsupported method %q", req.Method),
+		}
+	}
+
+	// Parse request target
+	req.RequestURI, reqLine, found = strings.Cut(reqLine, " ")
+	if !found {
+		return nil, badRequest("invalid request target")
+	}
+	if len(req.RequestURI) > maxRequestURIBytes {
+		return nil, &requestError{
+			statusCode: http.StatusRequestURITooLong,
+			err:        fmt.Errorf("request URI is %d bytes long", len(req.RequestURI)),
+		}
+	}
+	if req.URL, err = parseRequestTarget(req.Method, req.RequestURI); err != nil {
+		return nil, badRequest("invalid request target: %w", err)
+	}
+
+	// Parse protocol version "HTTP/1.0"
+	req.Proto = reqLine
+	req.ProtoMajor, req.ProtoMinor, found = parseProtocol(req.Proto)
+	if !found {
+		if _, _, ok := http.ParseHTTPVersion(req.Proto); ok {
+			return nil, &requestError{
+				statusCode: http.StatusHTTPVersionNotSupported,
+				err:        fmt.Errorf("unsupported proto %q", req.Proto),
+			}
+		}
+		return nil, badRequest("invalid proto %q", req.Proto)
+	}
+
+	// Parse headers
+	if req.Header, err = readHeader(headerReader); err != nil {
+		return nil, err
+	}
+
+	// A request has exactly one Host, except that HTTP/1.0 clients may
+	// leave it out. We don't insist on it for HTTP/1.1 either. In
+	// absolute-form the target's authority wins (RFC 9112 3.2.2).
+	hosts := req.Header.Values("Host")
+	if len(hosts) > 1 {
+		return nil, badRequest("multiple Host headers")
+	}
+	if len(hosts) == 1 {
+		if !validHost(hosts[0]) {
+			return nil, badRequest("invalid Host %q", hosts[0])
+		}
+		req.Host = hosts[0]
+	}
+	// Like net/http, the Host header is only available as req.Host.
+	req.Header.Del("Host")
+	if req.URL.Host != "" {
+		req.Host = req.URL.Host
+	}
+
+	// 100-continue is the only expectation defined (RFC 9110 10.1.1).
+	if expect := req.Header.Get("Expect"); expect != "" && !strings.EqualFold(expect, "100-continue") {
+		return nil, &requestError{
+			statusCode: http.StatusExpectationFailed,
+			err:        fmt.Errorf("unsupported expectation %q", expect),
+		}
+	}
+
+	if te := req.Header.Values("Transfer-Encoding"); len(te) > 0 {
+		// Proxies that pick a different one of the two to delimit the
+		// body would see a different next request, so we refuse to guess
+		// (RFC 9112 6.3).
+		if _, ok := req.Header["Content-Length"]; ok {
+			return nil, badRequest("both Transfer-Encoding and Content-Length are set")
+		}
+		// Chunked must be the final encoding, anything else can only be
+		// delimited by closing the connection which requests can't do
+		// (RFC 9112 6.3).
+		if len(te) != 1 || !strings.EqualFold(strings.TrimSpace(te[0]), "chunked") {
+			return nil, &requestError{
+				statusCode: http.StatusNotImplemented,
+				err:        fmt.Errorf("unsupported transfer encoding: %q", te),
+			}
+		}
+		// Like net/http, the framing is only available as
+		// req.TransferEncoding.
+		req.Header.Del("Transfer-Encoding")
+		req.TransferEncoding = []string{"chunked"}
+		req.ContentLength = -1
+		req.Trailer = http1.ParseTrailerKeys(req.Header)
+		req.Body = &bodyReader{reader: chunked.NewReader(reader, req.Trailer)}
+		return req, nil
+	}
+
+	contentLength, err := http1.ParseContentLength(req.Header.Values("Content-Length"))
+	if err != nil {
+		return nil, badRequest("invalid content length: %w", err)
+	}
+	// Without a Content-Length, a request has no body (RFC 9112 6.3).
+	req.ContentLength = max(contentLength, 0)
+	if cl := req.Header["Content-Length"]; len(cl) > 1 || len(cl) == 1 && strings.Contains(cl[0], ",") {
+		// A list of identical values, in one header or repeated ones,
+		// means the same as one value.
+		req.Header["Content-Length"] = []string{strconv.FormatInt(contentLength, 10)}
+	}
+	if req.ContentLength == 0 {
+		req.Body = noBody{}
+	} else {
+		req.Body = &bodyReader{reader: io.LimitReader(reader, req.ContentLength)}
+	}
+	return req, nil
+}
+
+// parseRequestTarget parses the request target in any of its four forms
+// (RFC 9112 3.2).
+func parseRequestTarget(method, target string) (*url.URL, error) {
+	switch {
+	case method == http.MethodConnect:
+		// authority-form, the host and port to open a tunnel to.
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" || !validHost(host) || !validPort(port) {
+			return nil, fmt.Errorf("invalid authority %q", target)
+		}
+		return &url.URL{Host: target}, nil
+	case target == "*":
+		// asterisk-form, addresses the server as a whole.
+		if method != http.MethodOptions {
+			return nil, fmt.Errorf("%s not allowed with *", method)
+		}
+		return &url.URL{Path: "*"}, nil
+	case strings.HasPrefix(target, "/"):
+		// origin-form, the usual absolute path and query.
+		return url.ParseRequestURI(target)
+	}
+
+	// absolute-form, sent to proxies. Servers must accept it too.
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" || !validHost(u.Host) {
+		return nil, fmt.Errorf("invalid host %q", u.Host)
+	}
+	return u, nil
+}
+
+// readHeader reads header fields up to the empty line that ends them. Keys
+// are canonicalized like net/http does. Anything that could be read
+// differently by another server or proxy in front of us is rejected
+// (RFC 9112 5).
+func readHeader(r *textproto.Reader) (http.Header, error) {
+	h := make(http.Header)
+	for {
+		line, err := r.ReadLineBytes()
+		if errors.Is(err, io.EOF) {
+			// The connection ended, or we hit the header size limit,
+			// before the empty line that ends the headers.
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			return h, nil
+		}
+
+		// A line starting with whitespace continues the previous one.
+		// This obsolete line folding must be rejected or replaced with
+		// spaces, we reject it (RFC 9112 5.2).
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, badRequest("obsolete line folding in header %q", line)
+		}
+		k, v, ok := bytes.Cut(line, []byte{':'})
+		if !ok {
+			return nil, badRequest("invalid header %q", line)
+		}
+		// No whitespace is allowed between the name and the colon
+		// (RFC 9112 5.1), so "Content-Length : 5" is invalid.
+		if !http1.ValidToken(string(k)) {
+			return nil, badRequest("invalid header name %q", k)
+		}
+		v = bytes.Trim(v, " \t")
+		if !validHeaderValue(v) {
+			return nil, badRequest("invalid value for header %q", k)
+		}
+		key := textproto.CanonicalMIMEHeaderKey(string(k))
+		h[key] = append(h[key], string(v))
+	}
+}
+
+// validHeaderValue reports whether v only holds visible characters, spaces
+// and tabs. Bare CR, LF and NUL are not allowed (RFC 9110 5.5).
+func validHeaderValue(v []byte) bool {
+	for _, c := range v {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func parseProtocol(proto string) (int, int, bool) {
+	switch proto {
+	case "HTTP/1.0":
+		return 1, 0, true
+	case "HTTP/1.1":
+		return 1, 1, true
+	}
+	return 0, 0, false
+}
+
+func methodValid(method string) bool {
+	return slices.Contains(supportedMethods, method)
+}
+
+// validHost reports whether h looks like a host with an optional port, as
+// used in the Host header and request targets (RFC 3986 3.2).
+func validHost(h string) bool {
+	for i := 0; i < len(h); i++ {
+		c := h[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("-._~!$&'()*+,;=:[]", c) >= 0:
+		case c == '%':
+			// Percent-encoding, e.g. "%25" before an IPv6 zone.
+			if i+2 >= len(h) || !isHex(h[i+1]) || !isHex(h[i+2]) {
+				return false
+			}
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func validPort(port string) bool {
+	n, err := strconv.ParseUint(port, 10, 16)
+	return err == nil && n > 0
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+func TestRequestTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		raw      string
+		wantURL  string
+		wantPath string
+		wantHost string
+	}{
+		{
+			name:     "origin-form",
+			raw:      "GET /a/b?c=d HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			wantURL:  "/a/b?c=d",
+			wantPath: "/a/b",
+			wantHost: "example.com",
+		},
+		{
+			name:     "origin-form without Host",
+			raw:      "GET / HTTP/1.0\r\n\r\n",
+			wantURL:  "/",
+			wantPath: "/",
+		},
+		{
+			name:     "absolute-form",
+			raw:      "GET http://example.com:8080/a?b=c HTTP/1.1\r\nHost: example.com:8080\r\n\r\n",
+			wantURL:  "http://example.com:8080/a?b=c",
+			wantPath: "/a",
+			wantHost: "example.com:8080",
+		},
+		{
+			name:     "absolute-form overrides Host",
+			raw:      "GET https://example.com/ HTTP/1.1\r\nHost: other.example\r\n\r\n",
+			wantURL:  "https://example.com/",
+			wantPath: "/",
+			wantHost: "example.com",
+		},
+		{
+			name:     "authority-form",
+			raw:      "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+			wantURL:  "//example.com:443",
+			wantHost: "example.com:443",
+		},
+		{
+			name:     "authority-form with IPv6",
+			raw:      "CONNECT [::1]:443 HTTP/1.1\r\n\r\n",
+			wantURL:  "//[::1]:443",
+			wantHost: "[::1]:443",
+		},
+		{
+			name:     "asterisk-form",
+			raw:      "OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			wantURL:  "*",
+			wantPath: "*",
+			wantHost: "example.com",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := readRequest(bufio.NewReader(strings.NewReader(tt.raw)))
+			if err != nil {
+				t.Fatalf("read request: %v", err)
+			}
+			if got := req.URL.String(); got != tt.wantURL {
+				t.Errorf("URL = %q, want %q", got, tt.wantURL)
+			}
+			if req.URL.Path != tt.wantPath {
+				t.Errorf("URL.Path = %q, want %q", req.URL.Path, tt.wantPath)
+			}
+			if req.Host != tt.wantHost {
+				t.Errorf("Host = %q, want %q", req.Host, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestInvalidRequestTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		raw  string
+	}{
+		{name: "CONNECT with a path", raw: "CONNECT /a HTTP/1.1\r\n\r\n"},
+		{name: "CONNECT without a port", raw: "CONNECT example.com HTTP/1.1\r\n\r\n"},
+		{name: "CONNECT with port zero", raw: "CONNECT example.com:0 HTTP/1.1\r\n\r\n"},
+		{name: "asterisk without OPTIONS", raw: "GET * HTTP/1.1\r\n\r\n"},
+		{name: "unsupported scheme", raw: "GET ftp://example.com/ HTTP/1.1\r\n\r\n"},
+		{name: "absolute-form without host", raw: "GET http:/a HTTP/1.1\r\n\r\n"},
+		{name: "multiple Host headers", raw: "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n"},
+		{name: "invalid Host", raw: "GET / HTTP/1.1\r\nHost: a/b\r\n\r\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readRequest(bufio.NewReader(strings.NewReader(tt.raw)))
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) || reqErr.statusCode != http.StatusBadRequest {
+				t.Fatalf("read request = %v, want %d", err, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestGeneralOptions(t *testing.T) {
+	called := false
+	handler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true })
+	addr := startServer(t, handler)
+
+	resp := roundTrip(t, addr, "OPTIONS * HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("response = %q, want 200", resp)
+	}
+	if want := "Allow: " + strings.Join(supportedMethods, ", ") + "\r\n"; !strings.Contains(resp, want) {
+		t.Fatalf("response = %q, want %q", resp, want)
+	}
+	if called {
+		t.Fatal("handler was called")
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"content-type: text/plain \t\r\n" +
+		"X-MULTI: a\r\n" +
+		"x-multi:b\r\n" +
+		"X-Empty:\r\n" +
+		"Content-Length: 3, 3\r\n" +
+		"Content-Length: 3\r\n" +
+		"\r\nabc"
+	req, err := readRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("read request: %v", err)
+	}
+
+	want := http.Header{
+		"Content-Type":   {"text/plain"},
+		"X-Multi":        {"a", "b"},
+		"X-Empty":        {""},
+		"Content-Length": {"3"},
+	}
+	if !reflect.DeepEqual(req.Header, want) {
+		t.Fatalf("header = %q, want %q", req.Header, want)
+	}
+	if req.ContentLength != 3 {
+		t.Fatalf("content length = %d, want 3", req.ContentLength)
+	}
+	if req.Host != "example.com" {
+		t.Fatalf("host = %q, want example.com", req.Host)
+	}
+}
+
+func TestInvalidHeader(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		header string
+	}{
+		{name: "obsolete line folding", header: "X-Folded: a\r\n b\r\n"},
+		{name: "obsolete line folding with tab", header: "X-Folded: a\r\n\tb\r\n"},
+		{name: "whitespace before colon", header: "Content-Length : 0\r\n"},
+		{name: "whitespace in name", header: "Content Length: 0\r\n"},
+		{name: "empty name", header: ": value\r\n"},
+		{name: "bare CR in value", header: "X-Value: a\rb\r\n"},
+		{name: "NUL in value", header: "X-Value: a\x00b\r\n"},
+		{name: "conflicting content lengths", header: "Content-Length: 3\r\nContent-Length: 4\r\n"},
+		{name: "conflicting content length list", header: "Content-Length: 3, 4\r\n"},
+		{name: "signed content length", header: "Content-Length: +3\r\n"},
+		{name: "empty content length", header: "Content-Length:\r\n"},
+		{name: "content length and chunked", header: "Content-Length: 3\r\nTransfer-Encoding: chunked\r\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "POST / HTTP/1.1\r\n" + tt.header + "\r\n"
+			_, err := readRequest(bufio.NewReader(strings.NewReader(raw)))
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) || reqErr.statusCode != http.StatusBadRequest {
+				t.Fatalf("read request = %v, want %d", err, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+// FuzzReadHeader checks that whatever headers are accepted come out in a form
+// no other HTTP implementation can read differently. The seed corpus in
+// testdata/fuzz holds request smuggling attempts.
+func FuzzReadHeader(f *testing.F) {
+	f.Add("Host: example.com\r\nContent-Length: 5\r\n")
+	f.Add("Transfer-Encoding: chunked\r\n")
+	f.Add("X-A: 1\r\nx-a: 2\r\n")
+	f.Fuzz(func(t *testing.T, header string) {
+		raw := "POST / HTTP/1.1\r\n" + header + "\r\n"
+		req, err := readRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			return
+		}
+		for k, vs := range req.Header {
+			if !http1.ValidToken(k) || k != textproto.CanonicalMIMEHeaderKey(k) {
+				t.Fatalf("accepted header name %q", k)
+			}
+			for _, v := range vs {
+				if strings.Trim(v, " \t") != v || !validHeaderValue([]byte(v)) {
+					t.Fatalf("accepted value %q for %q", v, k)
+				}
+			}
+		}
+		if len(req.TransferEncoding) > 0 && len(req.Header["Content-Length"]) > 0 {
+			t.Fatalf("accepted both Transfer-Encoding and Content-Length")
+		}
+	})
+}
+
+func TestParseForm(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.FormValue("a"), r.PostFormValue("b"), r.Form["c"])
+	}))
+
+	body := "b=2&c=3"
+	resp, err := http.Post("http://"+addr+"/?a=1&c=4", "application/x-www-form-urlencoded", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	// Values from the body come before those from the query.
+	if want := "1 2 [3 4]"; string(got) != want {
+		t.Fatalf("form = %q, want %q", got, want)
+	}
+}
+
+func TestMultipartForm(t *testing.T) {
+	tmpFile := make(chan string, 1)
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A small memory limit stores the file on disk.
+		if err := r.ParseMultipartForm(16); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, fh, err := r.FormFile("upload")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer func() { _ = f.Close() }()
+		if osFile, ok := f.(*os.File); ok {
+			tmpFile <- osFile.Name()
+		}
+		content, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.FormValue("name"), fh.Filename, content)
+	}))
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("name", "gopher"); err != nil {
+		t.Fatalf("write field: %v", err)
+	}
+	fw, err := mw.CreateFormFile("upload", "hello.txt")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	_, _ = io.WriteString(fw, strings.Repeat("hello ", 10))
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close multipart writer: %v", err)
+	}
+
+	resp, err := http.Post("http://"+addr+"/", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if want := "gopher hello.txt " + strings.Repeat("hello ", 10); string(got) != want {
+		t.Fatalf("response = %q, want %q", got, want)
+	}
+
+	// The server removes the temporary file once the handler is done.
+	var name string
+	select {
+	case name = <-tmpFile:
+	default:
+		t.Fatal("the uploaded file was not stored on disk")
+	}
+	if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stat %s = %v, want %v", name, err, os.ErrNotExist)
+	}
+}
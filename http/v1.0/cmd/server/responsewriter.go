@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/chunked"
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+// bufferedBodyBytes is how much of the body we hold back before sending the
+// headers. Responses that fit are sent with a Content-Length, larger ones
+// are streamed.
+const bufferedBodyBytes = 4096
+
+// sniffLen is how many bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+type responseBodyWriter struct {
+	proto       string
+	conn        net.Conn
+	reader      *bufio.Reader
+	req         *http.Request
+	sentHeaders bool
+	headers     http.Header
+	// status is the code passed to WriteHeader. Sending the headers is
+	// deferred until the buffer fills up or the end of the request, so we
+	// know the size of small bodies.
+	status int
+	// buf holds the start of the body until the headers are sent.
+	buf []byte
+	// closeAfter is set when the connection must be closed after this
+	// response, either because the client asked for it or because the body
+	// is delimited by closing the connection.
+	closeAfter bool
+	// body is where the response body is written once the headers are
+	// sent: the connection itself, a chunked encoder on top of it, or
+	// io.Discard for responses that must not have a body.
+	body    io.Writer
+	chunked *chunked.Writer
+	// written counts the body bytes the handler wrote. contentLength is the
+	// length announced in the headers once they are sent, or -1. Like
+	// net/http, we don't let a handler write more than it announced, the
+	// excess would be read as the next response.
+	written       int64
+	contentLength int64
+	// expectContinue is set if the client waits for "100 Continue" before
+	// sending the body, sentContinue once we did.
+	expectContinue bool
+	sentContinue   bool
+	// bgRead watches the connection while the handler runs, it must be
+	// stopped before anyone else reads from the connection.
+	bgRead   *backgroundRead
+	hijacked bool
+}
+
+func (r *responseBodyWriter) Header() http.Header {
+	return r.headers
+}
+
+func (r *responseBodyWriter) Write(b []byte) (int, error) {
+	if r.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if !http1.BodyAllowed(r.statusCode()) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if cl := r.declaredLength(); cl >= 0 && r.written+int64(len(b)) > cl {
+		return 0, http.ErrContentLength
+	}
+	if !r.sentHeaders {
+		if len(r.buf)+len(b) <= bufferedBodyBytes {
+			r.buf = append(r.buf, b...)
+			r.written += int64(len(b))
+			return len(b), nil
+		}
+		if err := r.sendHeaders(false, b); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.body.Write(b)
+	r.written += int64(n)
+	return n, err
+}
+
+// declaredLength returns the Content-Length set by the handler, or -1.
+func (r *responseBodyWriter) declaredLength() int64 {
+	if r.sentHeaders {
+		return r.contentLength
+	}
+	return headerContentLength(r.headers)
+}
+
+// shortBody reports whether the handler wrote less than it announced.
+func (r *responseBodyWriter) shortBody() bool {
+	return r.req.Method != http.MethodHead && r.contentLength >= 0 && r.written < r.contentLength
+}
+
+// ReadFrom is used by io.Copy. Once the headers are out, a body sent as is
+// is handed to the connection, which on TCP lets the kernel copy files to
+// the socket (sendfile) without passing them through user space. Bodies
+// whose length or content type we still have to work out go through Write.
+func (r *responseBodyWriter) ReadFrom(src io.Reader) (int64, error) {
+	if r.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if !r.sentHeaders {
+		_, typed := r.headers["Content-Type"]
+		if !typed || r.headers.Get("Content-Length") == "" || !http1.BodyAllowed(r.statusCode()) {
+			return io.Copy(writerOnly{r}, src)
+		}
+		if err := r.sendHeaders(false, nil); err != nil {
+			return 0, err
+		}
+	}
+	rf, ok := r.conn.(io.ReaderFrom)
+	if !ok || r.body != io.Writer(r.conn) {
+		return io.Copy(writerOnly{r}, src)
+	}
+	if r.contentLength < 0 {
+		n, err := rf.ReadFrom(src)
+		r.written += n
+		return n, err
+	}
+
+	// Copy no more than the announced length. io.CopyN already hands us a
+	// limited reader, which we pass on as is so the connection still finds
+	// the file underneath.
+	remaining := r.contentLength - r.written
+	lr, limited := src.(*io.LimitedReader)
+	if !limited || lr.N > remaining {
+		lr = &io.LimitedReader{R: src, N: remaining}
+	}
+	n, err := rf.ReadFrom(lr)
+	r.written += n
+	if err != nil || lr.R != src || lr.N > 0 {
+		return n, err
+	}
+	// We cut src off at the announced length, see if there was more.
+	if m, _ := src.Read(make([]byte, 1)); m > 0 {
+		return n, http.ErrContentLength
+	}
+	return n, nil
+}
+
+// writerOnly hides the ReadFrom method of a writer, so io.Copy doesn't call
+// it again.
+type writerOnly struct {
+	io.Writer
+}
+
+func (r *responseBodyWriter) WriteHeader(statusCode int) {
+	if r.hijacked {
+		slog.Warn(fmt.Sprintf("WriteHeader called on hijacked connection with: %d", statusCode))
+		return
+	}
+	if r.sentHeaders || r.status != 0 {
+		slog.Warn(fmt.Sprintf("WriteHeader called twice, second time with: %d", statusCode))
+		return
+	}
+	// Informational responses are sent right away and are followed by the
+	// final response. 101 Switching Protocols is final, the connection
+	// speaks another protocol afterwards.
+	if statusCode >= 100 && statusCode <= 199 && statusCode != http.StatusSwitchingProtocols {
+		if err := r.writeInformational(statusCode); err != nil {
+			slog.Error("failed to send informational response", "error", err)
+		}
+		return
+	}
+	r.status = statusCode
+}
+
+func (r *responseBodyWriter) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// writeInformational sends a 1xx response with the headers set so far, like
+// 103 Early Hints does. HTTP/1.0 clients don't understand them, so they
+// never see one.
+func (r *responseBodyWriter) writeInformational(statusCode int) error {
+	if !r.req.ProtoAtLeast(1, 1) {
+		return nil
+	}
+	if statusCode == http.StatusContinue {
+		if r.sentContinue {
+			return nil
+		}
+		r.sentContinue = true
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %d %s\r\n", r.proto, statusCode, http.StatusText(statusCode))
+	if statusCode != http.StatusContinue {
+		if err := r.headers.Write(&buf); err != nil {
+			return err
+		}
+	}
+	fmt.Fprint(&buf, "\r\n")
+	_, err := r.conn.Write(buf.Bytes())
+	return err
+}
+
+// finishRequest sends the headers and buffered body if the handler didn't
+// write enough to send them already, and terminates a chunked body.
+func (r *responseBodyWriter) finishRequest() error {
+	if !r.sentHeaders {
+		return r.sendHeaders(true, nil)
+	}
+	if r.shortBody() {
+		// The client waits for the rest of the body, only closing the
+		// connection tells it there is none.
+		r.closeAfter = true
+	}
+	if r.chunked != nil {
+		return r.chunked.Close()
+	}
+	return nil
+}
+
+// sendHeaders writes the status line, headers and the buffered start of the
+// body. finished reports whether the handler has returned, in which case
+// the buffer holds the whole body. next is the data about to be written,
+// used to sniff the content type of a body that exceeds the buffer.
+func (r *responseBodyWriter) sendHeaders(finished bool, next []byte) error {
+	r.sentHeaders = true
+	r.closeAfter = r.req.Close
+	r.body = r.conn
+	statusCode := r.statusCode()
+	isHead := r.req.Method == http.MethodHead
+
+	if _, ok := r.headers["Date"]; !ok {
+		r.headers.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	// A successful CONNECT turns the connection into a tunnel, anything
+	// after the headers belongs to it (RFC 9110 9.3.6).
+	tunnel := r.req.Method == http.MethodConnect && statusCode >= 200 && statusCode <= 299
+
+	switch {
+	case tunnel:
+		r.headers.Del("Content-Length")
+		r.headers.Del("Transfer-Encoding")
+		r.closeAfter = true
+	case http1.BodyAllowed(statusCode):
+		// Like net/http, setting the Content-Type key to nil disables
+		// sniffing.
+		if _, ok := r.headers["Content-Type"]; !ok && r.headers.Get("Content-Encoding") == "" {
+			if sample := sniffSample(r.buf, next); len(sample) > 0 {
+				r.headers.Set("Content-Type", http.DetectContentType(sample))
+			}
+		}
+		if r.headers.Get("Content-Length") == "" {
+			switch {
+			case finished:
+				r.headers.Set("Content-Length", strconv.Itoa(len(r.buf)))
+			case isHead:
+				// We don't know the length and there is no body to
+				// delimit.
+			case r.req.ProtoAtLeast(1, 1):
+				// Stream the body in chunks so we don't have to buffer it
+				// to learn its length.
+				r.headers.Set("Transfer-Encoding", "chunked")
+				r.chunked = chunked.NewWriter(r.conn)
+				r.body = r.chunked
+			default:
+				// HTTP/1.0 clients don't understand chunks, the only way
+				// to tell them where the body ends is to close the
+				// connection (RFC 9112 6.3).
+				r.closeAfter = true
+			}
+		}
+	default:
+		// 1xx, 204 and 304 responses never have a body (RFC 9110 6.4.1).
+		r.headers.Del("Transfer-Encoding")
+		if statusCode != http.StatusNotModified {
+			r.headers.Del("Content-Length")
+		}
+	}
+	if isHead || !http1.BodyAllowed(statusCode) {
+		r.body = io.Discard
+		r.chunked = nil
+	}
+	r.contentLength = -1
+	if !tunnel && http1.BodyAllowed(statusCode) {
+		r.contentLength = headerContentLength(r.headers)
+	}
+	if finished && r.shortBody() {
+		r.closeAfter = true
+	}
+
+	// The client hasn't sent the body it announced, so we can't read the
+	// next request from this connection (RFC 9110 10.1.1).
+	if r.expectContinue && !r.sentContinue {
+		r.closeAfter = true
+	}
+	switch {
+	case tunnel:
+		// Connection options would apply to the tunnel.
+	case r.closeAfter && r.req.ProtoAtLeast(1, 1):
+		r.headers.Set("Connection", "close")
+	case !r.closeAfter && !r.req.ProtoAtLeast(1, 1):
+		r.headers.Set("Connection", "keep-alive")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %d %s\r\n", r.proto, statusCode, http.StatusText(statusCode))
+	if err := r.headers.Write(&buf); err != nil {
+		return err
+	}
+	fmt.Fprint(&buf, "\r\n")
+	if _, err := r.conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	body := r.buf
+	r.buf = nil
+	_, err := r.body.Write(body)
+	return err
+}
+
+// Flush sends the headers and any buffered data to the client.
+func (r *responseBodyWriter) Flush() {
+	if err := r.FlushError(); err != nil {
+		slog.Error("failed to flush response", "error", err)
+	}
+}
+
+// FlushError is like Flush but returns the error, it is preferred by
+// http.ResponseController.
+func (r *responseBodyWriter) FlushError() error {
+	if r.hijacked {
+		return http.ErrHijacked
+	}
+	if !r.sentHeaders {
+		return r.sendHeaders(false, nil)
+	}
+	return nil
+}
+
+// Hijack lets the handler take over the connection, e.g. to speak WebSocket.
+// Headers and data written before are sent first. Afterwards the server no
+// longer reads from, writes to or closes the connection.
+func (r *responseBodyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if r.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	if !r.sentHeaders && (r.status != 0 || len(r.buf) > 0) {
+		if err := r.sendHeaders(false, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	r.stopBackgroundRead()
+	if err := r.conn.SetDeadline(time.Time{}); err != nil {
+		return nil, nil, err
+	}
+	r.hijacked = true
+	return r.conn, bufio.NewReadWriter(r.reader, bufio.NewWriter(r.conn)), nil
+}
+
+// SetReadDeadline sets the deadline for reading the request body.
+func (r *responseBodyWriter) SetReadDeadline(t time.Time) error {
+	return r.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writing the response.
+func (r *responseBodyWriter) SetWriteDeadline(t time.Time) error {
+	return r.conn.SetWriteDeadline(t)
+}
+
+// EnableFullDuplex is a no-op: handlers may always read the request body
+// while writing the response.
+func (r *responseBodyWriter) EnableFullDuplex() error {
+	return nil
+}
+
+// stopBackgroundRead stops watching the connection for a client that went
+// away, and reports if it did.
+func (r *responseBodyWriter) stopBackgroundRead() error {
+	if r.bgRead == nil {
+		return nil
+	}
+	err := r.bgRead.stop()
+	r.bgRead = nil
+	return err
+}
+
+// headerContentLength returns the Content-Length in h, or -1.
+func headerContentLength(h http.Header) int64 {
+	cl, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if err != nil || cl < 0 {
+		return -1
+	}
+	return cl
+}
+
+// sniffSample returns up to sniffLen bytes of buf followed by next.
+func sniffSample(buf, next []byte) []byte {
+	if len(buf) >= sniffLen {
+		return buf[:sniffLen]
+	}
+	sample := make([]byte, 0, sniffLen)
+	sample = append(sample, buf...)
+	return append(sample, next[:min(len(next), sniffLen-len(buf))]...)
+}
+
+// expectContinueReader sends "100 Continue" when the handler first reads a
+// body the client announced with "Expect: 100-continue". Handlers that
+// respond without reading the body spare the client from sending it.
+type expectContinueReader struct {
+	body io.ReadCloser
+	w    *responseBodyWriter
+}
+
+func (e *expectContinueReader) Read(p []byte) (int, error) {
+	if !e.w.sentContinue && !e.w.sentHeaders {
+		if err := e.w.writeInformational(http.StatusContinue); err != nil {
+			return 0, err
+		}
+	}
+	return e.body.Read(p)
+}
+
+func (e *expectContinueReader) Close() error {
+	return e.body.Close()
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "<html><body>hello</body></html>")
+	}))
+
+	for _, tt := range []struct {
+		method   string
+		wantBody string
+	}{
+		{method: http.MethodGet, wantBody: "<html><body>hello</body></html>"},
+		{method: http.MethodHead, wantBody: ""},
+	} {
+		t.Run(tt.method, func(t *testing.T) {
+			raw := tt.method + " / HTTP/1.1\r\nConnection: close\r\n\r\n"
+			resp := roundTrip(t, addr, raw)
+			head, body, _ := strings.Cut(resp, "\r\n\r\n")
+			if body != tt.wantBody {
+				t.Fatalf("body = %q, want %q", body, tt.wantBody)
+			}
+
+			r, err := http.ReadResponse(bufio.NewReader(strings.NewReader(head+"\r\n\r\n")), nil)
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			if got := r.Header.Get("Content-Length"); got != "31" {
+				t.Fatalf("Content-Length = %q, want %q", got, "31")
+			}
+			if got := r.Header.Get("Content-Type"); got != "text/html; charset=utf-8" {
+				t.Fatalf("Content-Type = %q, want sniffed text/html", got)
+			}
+			if _, err := http.ParseTime(r.Header.Get("Date")); err != nil {
+				t.Fatalf("invalid Date header: %v", err)
+			}
+		})
+	}
+}
+
+func TestBodyNotAllowed(t *testing.T) {
+	writeErr := make(chan error, 1)
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		_, err := io.WriteString(w, "not allowed")
+		writeErr <- err
+	}))
+
+	resp := roundTrip(t, addr, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 204 No Content\r\n") {
+		t.Fatalf("response = %q, want 204", resp)
+	}
+	if strings.Contains(resp, "Content-Length") || strings.Contains(resp, "not allowed") {
+		t.Fatalf("204 response has a body: %q", resp)
+	}
+	if err := <-writeErr; !errors.Is(err, http.ErrBodyNotAllowed) {
+		t.Fatalf("Write = %v, want %v", err, http.ErrBodyNotAllowed)
+	}
+}
+
+func TestContentLengthMismatch(t *testing.T) {
+	writeErr := make(chan error, 2)
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		switch r.URL.Path {
+		case "/long":
+			_, err := io.WriteString(w, "hello world")
+			writeErr <- err
+			_, _ = io.WriteString(w, "hello")
+		case "/long-copy":
+			// Goes through ReadFrom, which hands the body to the
+			// connection. The limit hides the WriteTo method of the
+			// strings.Reader.
+			w.Header().Set("Content-Type", "text/plain")
+			_, err := io.Copy(w, io.LimitReader(strings.NewReader("hello world"), 100))
+			writeErr <- err
+		case "/short":
+			_, _ = io.WriteString(w, "hel")
+		case "/short-flushed":
+			_, _ = io.WriteString(w, "hel")
+			w.(http.Flusher).Flush()
+		}
+	}))
+
+	// Writing more than announced fails, so the next response on the
+	// connection starts where the client expects it.
+	resp := roundTrip(t, addr, "GET /long HTTP/1.1\r\n\r\nGET /long HTTP/1.1\r\nConnection: close\r\n\r\n")
+	if got := strings.Count(resp, "HTTP/1.1 200 OK\r\n"); got != 2 || !strings.HasSuffix(resp, "\r\n\r\nhello") {
+		t.Fatalf("response = %q, want two responses with body hello", resp)
+	}
+	if err := <-writeErr; !errors.Is(err, http.ErrContentLength) {
+		t.Fatalf("Write = %v, want %v", err, http.ErrContentLength)
+	}
+
+	resp = roundTrip(t, addr, "GET /long-copy HTTP/1.1\r\nConnection: close\r\n\r\n")
+	if !strings.HasSuffix(resp, "\r\n\r\nhello") {
+		t.Fatalf("response = %q, want body hello", resp)
+	}
+	if err := <-writeErr; !errors.Is(err, http.ErrContentLength) {
+		t.Fatalf("Copy = %v, want %v", err, http.ErrContentLength)
+	}
+
+	// Writing less closes the connection, which roundTrip waits for.
+	for _, path := range []string{"/short", "/short-flushed"} {
+		t.Run(path, func(t *testing.T) {
+			resp := roundTrip(t, addr, "GET "+path+" HTTP/1.1\r\n\r\n")
+			if !strings.Contains(resp, "Content-Length: 5\r\n") || !strings.HasSuffix(resp, "\r\n\r\nhel") {
+				t.Fatalf("response = %q, want the short body", resp)
+			}
+		})
+	}
+}
+
+func TestLargeResponseIsStreamed(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, strings.Repeat("a", 2*bufferedBodyBytes))
+	}))
+
+	for _, tt := range []struct {
+		proto     string
+		wantChunk bool
+	}{
+		{proto: "HTTP/1.1", wantChunk: true},
+		{proto: "HTTP/1.0", wantChunk: false},
+	} {
+		t.Run(tt.proto, func(t *testing.T) {
+			raw := "GET / " + tt.proto + "\r\nConnection: close\r\n\r\n"
+			r, err := http.ReadResponse(bufio.NewReader(strings.NewReader(roundTrip(t, addr, raw))), nil)
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			if got := len(r.TransferEncoding) > 0; got != tt.wantChunk {
+				t.Fatalf("chunked = %t, want %t", got, tt.wantChunk)
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if len(body) != 2*bufferedBodyBytes {
+				t.Fatalf("body length = %d, want %d", len(body), 2*bufferedBodyBytes)
+			}
+		})
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.Copy(w, r.Body)
+	}))
+
+	t.Run("handler reads body", func(t *testing.T) {
+		conn := dialAndSend(t, addr, "POST / HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+		br := bufio.NewReader(conn)
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if line != "HTTP/1.1 100 Continue\r\n" {
+			t.Fatalf("status line = %q, want 100 Continue", line)
+		}
+		if _, err := br.ReadString('\n'); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+
+		if _, err := io.WriteString(conn, "hello"); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		if string(body) != "hello" {
+			t.Fatalf("body = %q, want %q", body, "hello")
+		}
+	})
+
+	t.Run("handler rejects", func(t *testing.T) {
+		resp := roundTrip(t, addr, "POST /reject HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+		r, err := http.ReadResponse(bufio.NewReader(strings.NewReader(resp)), nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		if r.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", r.StatusCode, http.StatusUnauthorized)
+		}
+		if !r.Close {
+			t.Fatal("connection should be closed since the body was never sent")
+		}
+	})
+}
+
+func TestEarlyHints(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		_, _ = io.WriteString(w, "ok")
+	}))
+
+	resp := roundTrip(t, addr, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	want := "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\nHTTP/1.1 200 OK\r\n"
+	if !strings.HasPrefix(resp, want) {
+		t.Fatalf("response = %q, want prefix %q", resp, want)
+	}
+}
+
+func TestResponseControllerFlush(t *testing.T) {
+	next := make(chan struct{})
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		rc := http.NewResponseController(w)
+		for _, event := range []string{"data: one\n\n", "data: two\n\n"} {
+			_, _ = io.WriteString(w, event)
+			if err := rc.Flush(); err != nil {
+				t.Errorf("flush: %v", err)
+				return
+			}
+			<-next
+		}
+	}))
+
+	conn := dialAndSend(t, addr, "GET /events HTTP/1.1\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	br := bufio.NewReader(resp.Body)
+	for _, want := range []string{"data: one\n", "data: two\n"} {
+		// Each event must arrive while the handler is still running.
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		if line != want {
+			t.Fatalf("event = %q, want %q", line, want)
+		}
+		if _, err := br.ReadString('\n'); err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		next <- struct{}{}
+	}
+}
+
+func TestResponseControllerDeadlines(t *testing.T) {
+	errs := make(chan error, 2)
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		rc := http.NewResponseController(w)
+		errs <- rc.SetReadDeadline(time.Now().Add(time.Minute))
+		errs <- rc.SetWriteDeadline(time.Now().Add(time.Minute))
+	}))
+
+	_ = roundTrip(t, addr, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("set deadline: %v", err)
+		}
+	}
+}
+
+func TestHijack(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		conn, bufrw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		_, _ = bufrw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		_ = bufrw.Flush()
+		line, err := bufrw.ReadString('\n')
+		if err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+		_, _ = bufrw.WriteString("echo: " + line)
+		_ = bufrw.Flush()
+
+		if _, err := w.Write([]byte("too late")); !errors.Is(err, http.ErrHijacked) {
+			t.Errorf("Write after Hijack = %v, want %v", err, http.ErrHijacked)
+		}
+	}))
+
+	// The line after the request is read by the server before the handler
+	// hijacks the connection, so it must be handed over in the bufio.Reader.
+	conn := dialAndSend(t, addr, "GET / HTTP/1.1\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nhello\n")
+	resp := readAll(t, conn)
+	want := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\necho: hello\n"
+	if resp != want {
+		t.Fatalf("response = %q, want %q", resp, want)
+	}
+}
+
+func TestClientGoneCancelsContext(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	addr := startServer(t, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+
+	conn := dialAndSend(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	<-started
+	_ = conn.Close()
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request context was not canceled after the client went away")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/websocket"
+)
+
+// maxDecodedBodyBytes limits compressed request bodies once decoded.
+const maxDecodedBodyBytes = 10 << 20
+
+// newMux returns the routes of the demo server, with static files served
+// from publicDir. The conformance tests serve the same mux with net/http.
+func newMux(publicDir string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", newFileServer(http.Dir(publicDir)))
+	mux.Handle("/echo", decompressRequest(maxDecodedBodyBytes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		if _, err := io.Copy(w, r.Body); err != nil {
+			slog.Error("echo copy failed", "error", err)
+		}
+	})))
+	mux.HandleFunc("/status/{status}", func(w http.ResponseWriter, r *http.Request) {
+		status, err := strconv.ParseInt(r.PathValue("status"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			if _, err := fmt.Fprintf(w, "error: %s", err); err != nil {
+				slog.Error("failed to write error response", "error", err)
+			}
+			return
+		}
+		w.WriteHeader(int(status))
+	})
+	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("content-type", "application/json")
+		if err := json.NewEncoder(w).Encode(r.Header); err != nil {
+			slog.Error("headers encode failed", "error", err)
+		}
+	})
+	mux.HandleFunc("/nothing", func(_ http.ResponseWriter, _ *http.Request) {})
+	mux.HandleFunc("/ws", websocketEcho)
+	return mux
+}
+
+// websocketEcho sends every WebSocket message back to the client.
+func websocketEcho(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		slog.Error("websocket upgrade failed", "error", err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				slog.Error("websocket read failed", "error", err)
+			}
+			return
+		}
+		if err := conn.WriteMessage(typ, msg); err != nil {
+			slog.Error("websocket write failed", "error", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Server struct {
+	Addr    string
+	Handler http.Handler
+
+	// ReadTimeout is the maximum duration for reading the entire request,
+	// including the body.
+	ReadTimeout time.Duration
+	// ReadHeaderTimeout is the amount of time allowed to read the request
+	// line and headers. If zero, ReadTimeout is used.
+	ReadHeaderTimeout time.Duration
+	// WriteTimeout is the maximum duration before timing out writes of the
+	// response. It is reset whenever a new request's header is read.
+	WriteTimeout time.Duration
+	// IdleTimeout is the maximum amount of time to wait for the next
+	// request on a persistent connection. If zero, ReadTimeout is used.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes controls the maximum number of bytes the server will
+	// read parsing the request line and headers. If zero, 1MB is used.
+	MaxHeaderBytes int
+	// MaxBodyBytes limits the size of request bodies. Requests announcing a
+	// larger Content-Length are answered with 413 Request Entity Too Large
+	// without calling the handler. Reading further into a chunked body
+	// fails with *http.MaxBytesError, and the connection is closed after
+	// the response. If zero, bodies are not limited.
+	MaxBodyBytes int64
+
+	// MaxConns limits the number of connections served at once. Once it is
+	// reached, a newly accepted connection waits until an idle keep-alive
+	// connection is closed to make room or a connection is done, and Serve
+	// stops accepting meanwhile, leaving clients to wait in the listen
+	// backlog. Hijacked connections, e.g. WebSockets and CONNECT tunnels,
+	// count until their handler returns. If zero, connections are not
+	// limited.
+	MaxConns int
+	// RateLimit is the number of requests per second allowed per client IP
+	// address, enforced with a token bucket holding RateBurst tokens.
+	// Requests over the limit are answered with 503 Service Unavailable
+	// and a Retry-After header, and the connection is closed. If zero,
+	// requests are not limited.
+	RateLimit float64
+	// RateBurst is how many requests a client may send at once before
+	// RateLimit applies. If zero, RateLimit rounded up is used.
+	RateBurst int
+
+	// DisableGeneralOptionsHandler, if true, passes "OPTIONS *" requests to
+	// the Handler, otherwise they are answered with 200 OK and an Allow
+	// header.
+	DisableGeneralOptionsHandler bool
+
+	// H2C enables HTTP/2 over cleartext TCP for clients with prior
+	// knowledge (RFC 9113 3.3): a connection that starts with the HTTP/2
+	// preface is served by the http2 package, with the same handler and
+	// limits. Upgrading from HTTP/1.1 with "Upgrade: h2c" is not supported.
+	H2C bool
+
+	// TLSConfig optionally provides a TLS configuration for use by ServeTLS
+	// and ListenAndServeTLS.
+	TLSConfig *tls.Config
+
+	// ErrorLog receives connection errors, including malformed requests
+	// that were answered with an error status. The status is logged in the
+	// "status" attribute. If nil, slog's default logger is used.
+	ErrorLog *slog.Logger
+
+	inShutdown atomic.Bool
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]connState
+	// connSlots holds a value per connection being served if MaxConns is
+	// set.
+	connSlots chan struct{}
+	limiter   *rateLimiter
+	// baseCtx is the parent of every request context. It is canceled by
+	// Shutdown and Close so long running handlers can stop.
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+}
+
+// connState tells Shutdown whether a connection can be closed right away.
+type connState int
+
+const (
+	// stateActive means a request is being read or handled.
+	stateActive connState = iota
+	// stateIdle means the connection is waiting for the next request.
+	stateIdle
+	// stateNew means the connection is waiting for its first request.
+	// Shutdown closes it like an idle one, but it isn't closed to make
+	// room for other connections, it may have just been accepted.
+	stateNew
+)
+
+// shutdownPollInterval is how often Shutdown checks for idle connections.
+const shutdownPollInterval = 10 * time.Millisecond
+
+func (s *Server) ServeAndListen() error {
+	if s.shuttingDown() {
+		return http.ErrServerClosed
+	}
+	var lc net.ListenConfig
+	l, err := lc.Listen(context.Background(), "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Shutdown or Close is called, after
+// which it returns http.ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	handler := s.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	if !s.trackListener(l) {
+		_ = l.Close()
+		return http.ErrServerClosed
+	}
+	defer func() { _ = l.Close() }()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return http.ErrServerClosed
+			}
+			return err
+		}
+		// We don't accept more connections while this one waits.
+		if !s.acquireConnSlot() {
+			_ = conn.Close()
+			return http.ErrServerClosed
+		}
+		if !s.trackConn(conn, stateActive) {
+			s.releaseConnSlot()
+			_ = conn.Close()
+			continue
+		}
+
+		go func() {
+			defer s.releaseConnSlot()
+			defer s.untrackConn(conn)
+			if err := s.handleConnection(handler, conn); err != nil {
+				s.logConnError(conn, err)
+			}
+		}()
+	}
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting connections,
+// cancels the context of in-flight requests, closes idle connections and
+// then waits for the remaining connections to finish their current request.
+// If ctx expires first, the remaining connections are closed and the
+// context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	err := s.closeListenerLocked()
+	s.cancelBaseLocked()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			_ = s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes the listener and all connections, without
+// waiting for in-flight requests to finish.
+func (s *Server) Close() error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.closeListenerLocked()
+	s.cancelBaseLocked()
+	for conn := range s.conns {
+		_ = conn.Close()
+		delete(s.conns, conn)
+	}
+	return err
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown() {
+		return false
+	}
+	s.listener = l
+	if s.MaxConns > 0 && s.connSlots == nil {
+		s.connSlots = make(chan struct{}, s.MaxConns)
+	}
+	if s.RateLimit > 0 && s.limiter == nil {
+		s.limiter = newRateLimiter(s.RateLimit, s.RateBurst)
+	}
+	return true
+}
+
+// acquireConnSlot waits until another connection may be served, closing
+// idle connections while it waits. It returns false if the server shuts
+// down first.
+func (s *Server) acquireConnSlot() bool {
+	if s.connSlots == nil {
+		return true
+	}
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	default:
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		// All slots are taken, make room by closing a connection that is
+		// only waiting for its next request.
+		s.closeIdleConn()
+		select {
+		case s.connSlots <- struct{}{}:
+			return true
+		case <-s.baseContext().Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) releaseConnSlot() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+// allowRequest reports whether the rate limit lets the client on conn send
+// another request, and otherwise how long it has to wait.
+func (s *Server) allowRequest(conn net.Conn) (bool, time.Duration) {
+	if s.limiter == nil {
+		return true, 0
+	}
+	return s.limiter.allow(remoteIP(conn), time.Now())
+}
+
+// NumConns returns the number of open connections, and how many of them
+// are idle between requests. Hijacked connections count as busy until their
+// handler returns, like for MaxConns.
+func (s *Server) NumConns() (total, idle int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, state := range s.conns {
+		if state == stateIdle {
+			idle++
+		}
+	}
+	return len(s.conns), idle
+}
+
+func (s *Server) closeListenerLocked() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	s.listener = nil
+	return err
+}
+
+// trackConn records the state of conn. It returns false if the server is
+// shutting down and the connection should be closed instead.
+func (s *Server) trackConn(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connState)
+	}
+	s.conns[conn] = state
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// closeIdleConn closes one idle connection, if there is one.
+func (s *Server) closeIdleConn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == stateIdle {
+			_ = conn.Close()
+			delete(s.conns, conn)
+			return
+		}
+	}
+}
+
+// closeIdleConns closes all idle connections and reports whether all
+// connections are gone.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == stateIdle || state == stateNew {
+			_ = conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) baseContext() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baseContextLocked()
+}
+
+func (s *Server) baseContextLocked() context.Context {
+	if s.baseCtx == nil {
+		s.baseCtx, s.cancelBase = context.WithCancel(context.Background())
+	}
+	return s.baseCtx
+}
+
+func (s *Server) cancelBaseLocked() {
+	s.baseContextLocked()
+	s.cancelBase()
+}
+
+func (s *Server) logConnError(conn net.Conn, err error) {
+	logger := s.ErrorLog
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attrs := []any{"error", err, "remote_addr", conn.RemoteAddr().String()}
+	if status := errorStatusCode(err); status != 0 {
+		attrs = append(attrs, "status", status)
+	}
+	logger.Error("http error", attrs...)
+}
+
+func (s *Server) readHeaderTimeout() time.Duration {
+	if s.ReadHeaderTimeout > 0 {
+		return s.ReadHeaderTimeout
+	}
+	return s.ReadTimeout
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	return s.ReadTimeout
+}
+
+func (s *Server) maxHeaderBytes() int64 {
+	if s.MaxHeaderBytes > 0 {
+		return int64(s.MaxHeaderBytes)
+	}
+	return defaultMaxHeaderBytes
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	return startTestServer(t, &Server{Handler: handler})
+}
+
+// startTestServer serves s on a random free port until the test ends and
+// returns its address.
+func startTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+
+	var lc net.ListenConfig
+	lis, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(func() { _ = s.Close() })
+	return lis.Addr().String()
+}
+
+// roundTrip writes raw to a new connection and returns everything the server
+// sends until it closes the connection.
+func roundTrip(t *testing.T, addr, raw string) string {
+	t.Helper()
+	conn := dialAndSend(t, addr, raw)
+	defer func() { _ = conn.Close() }()
+	return readAll(t, conn)
+}
+
+// dialAndSend writes raw to a new connection, which is closed when the test
+// ends.
+func dialAndSend(t *testing.T, addr, raw string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("set deadline: %v", err)
+	}
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return conn
+}
+
+// readAll reads from conn until the server closes it.
+func readAll(t *testing.T, conn net.Conn) string {
+	t.Helper()
+
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(resp)
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "done")
+	})}
+	addr := startTestServer(t, s)
+
+	conn := dialAndSend(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	<-started
+
+	shutdownErr := make(chan error)
+	go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned before the request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if got := readAll(t, conn); !strings.HasSuffix(got, "\r\n\r\ndone") {
+		t.Fatalf("response = %q, want body %q", got, "done")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("server still accepts connections after Shutdown")
+	}
+}
+
+func TestShutdownCancelsRequestContext(t *testing.T) {
+	started := make(chan struct{})
+	s := &Server{Handler: http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})}
+	addr := startTestServer(t, s)
+
+	_ = dialAndSend(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	s := &Server{Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		close(started)
+		<-release
+	})}
+	addr := startTestServer(t, s)
+
+	_ = dialAndSend(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	s := &Server{Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})}
+	addr := startTestServer(t, s)
+
+	conn := dialAndSend(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestServeAfterClose(t *testing.T) {
+	s := &Server{}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := s.ServeAndListen(); !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("ServeAndListen = %v, want %v", err, http.ErrServerClosed)
+	}
+}
+
+// waitForConns waits until s has total open connections.
+func waitForConns(t *testing.T, s *Server, total int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := s.NumConns()
+		if got == total {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connections = %d, want %d", got, total)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	release := make(chan struct{})
+	s := &Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/block" {
+				<-release
+			}
+			_, _ = io.WriteString(w, r.URL.Path)
+		}),
+		MaxConns: 1,
+	}
+	addr := startTestServer(t, s)
+
+	blocked := dialAndSend(t, addr, "GET /block HTTP/1.1\r\nConnection: close\r\n\r\n")
+	waitForConns(t, s, 1)
+
+	// The second connection waits in the backlog until the first is done.
+	waiting := dialAndSend(t, addr, "GET /next HTTP/1.1\r\nConnection: close\r\n\r\n")
+	time.Sleep(50 * time.Millisecond)
+	if total, _ := s.NumConns(); total != 1 {
+		t.Fatalf("connections = %d, want 1", total)
+	}
+
+	close(release)
+	if got := readAll(t, blocked); !strings.HasSuffix(got, "/block") {
+		t.Fatalf("first response = %q, want body /block", got)
+	}
+	if got := readAll(t, waiting); !strings.HasSuffix(got, "/next") {
+		t.Fatalf("second response = %q, want body /next", got)
+	}
+}
+
+func TestMaxConnsClosesIdleConnection(t *testing.T) {
+	s := &Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.URL.Path)
+		}),
+		MaxConns: 1,
+	}
+	addr := startTestServer(t, s)
+
+	idle := dialAndSend(t, addr, "GET /idle HTTP/1.1\r\n\r\n")
+	br := bufio.NewReader(idle)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	_ = resp.Body.Close()
+	if total, idleConns := s.NumConns(); total != 1 || idleConns != 1 {
+		t.Fatalf("connections = %d, idle = %d, want 1, 1", total, idleConns)
+	}
+
+	// The idle connection makes room for a new one.
+	if got := roundTrip(t, addr, "GET /next HTTP/1.1\r\nConnection: close\r\n\r\n"); !strings.HasSuffix(got, "/next") {
+		t.Fatalf("response = %q, want body /next", got)
+	}
+	if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Fatalf("idle connection read = %v, want EOF", err)
+	}
+}
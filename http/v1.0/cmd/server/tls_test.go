@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestServeTLS(t *testing.T) {
+	var certs []tls.Certificate
+	pool := x509.NewCertPool()
+	for _, host := range []string{"example.com", "example.org"} {
+		cert, err := selfSignedCertificate(host)
+		if err != nil {
+			t.Fatalf("generate certificate: %v", err)
+		}
+		certs = append(certs, cert)
+		pool.AddCert(cert.Leaf)
+	}
+
+	s := &Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil {
+				t.Error("request has no TLS connection state")
+				return
+			}
+			_, _ = io.WriteString(w, r.TLS.ServerName)
+		}),
+		TLSConfig: &tls.Config{Certificates: certs},
+	}
+	var lc net.ListenConfig
+	lis, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = s.ServeTLS(lis, "", "") }()
+	t.Cleanup(func() { _ = s.Close() })
+
+	// Dial our listener regardless of the host name in the URL, so we can
+	// exercise SNI.
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, lis.Addr().String())
+		},
+	}}
+	t.Cleanup(client.CloseIdleConnections)
+
+	for _, host := range []string{"example.com", "example.org"} {
+		t.Run(host, func(t *testing.T) {
+			resp, err := client.Get("https://" + host + "/")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if string(body) != host {
+				t.Fatalf("server name = %q, want %q", body, host)
+			}
+			if got := resp.TLS.PeerCertificates[0].DNSNames[0]; got != host {
+				t.Fatalf("certificate for %q, want %q", got, host)
+			}
+		})
+	}
+}
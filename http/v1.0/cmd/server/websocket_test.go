@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/websocket"
+)
+
+func TestWebSocketEcho(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", websocketEcho)
+	addr := startServer(t, mux)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := websocket.Dial(ctx, "ws://"+addr+"/ws")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	for _, tt := range []struct {
+		typ websocket.MessageType
+		msg string
+	}{
+		{typ: websocket.TextMessage, msg: "Hello, World"},
+		{typ: websocket.BinaryMessage, msg: "\x00\x01\x02"},
+		// Needs the 64-bit length encoding.
+		{typ: websocket.TextMessage, msg: strings.Repeat("x", 70000)},
+	} {
+		if err := conn.WriteMessage(tt.typ, []byte(tt.msg)); err != nil {
+			t.Fatalf("write message: %v", err)
+		}
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read message: %v", err)
+		}
+		if typ != tt.typ || string(msg) != tt.msg {
+			t.Fatalf("echo = %d %.20q, want %d %.20q", typ, msg, tt.typ, tt.msg)
+		}
+	}
+
+	// The server answers pings while it waits for messages.
+	if err := conn.Ping([]byte("ping")); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	if err := conn.WriteClose(websocket.StatusNormalClosure, "done"); err != nil {
+		t.Fatalf("write close: %v", err)
+	}
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.StatusNormalClosure {
+		t.Fatalf("read message = %v, want close %d", err, websocket.StatusNormalClosure)
+	}
+}
+
+func TestWebSocketBadHandshake(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", websocketEcho)
+	addr := startServer(t, mux)
+
+	for _, tt := range []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "not an upgrade",
+			raw:  "GET /ws HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n",
+			want: "HTTP/1.1 400 Bad Request\r\n",
+		},
+		{
+			name: "HTTP/1.0",
+			raw: "GET /ws HTTP/1.0\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+				"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n",
+			want: "HTTP/1.0 400 Bad Request\r\n",
+		},
+		{
+			name: "unsupported version",
+			raw: "GET /ws HTTP/1.1\r\nHost: x\r\nConnection: Upgrade, close\r\nUpgrade: websocket\r\n" +
+				"Sec-WebSocket-Version: 8\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n",
+			want: "HTTP/1.1 426 Upgrade Required\r\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := roundTrip(t, addr, tt.raw)
+			if !strings.HasPrefix(resp, tt.want) {
+				t.Fatalf("response = %q, want prefix %q", resp, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebSocketUpgradeResponse(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", websocketEcho)
+	addr := startServer(t, mux)
+
+	// Example handshake from RFC 6455 1.3.
+	conn := dialAndSend(t, addr, "GET /ws HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	want := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n\r\n"
+	if got := string(buf[:n]); got != want {
+		t.Fatalf("response = %q, want %q", got, want)
+	}
+}
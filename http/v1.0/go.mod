@@ -1,3 +1,7 @@
 module github.com/fredrikaverpil/go-playground/http/v1.0
 
-go 1.23
+go 1.23.0
+
+require golang.org/x/net v0.43.0
+
+require golang.org/x/text v0.28.0 // indirect
@@ -0,0 +1,217 @@
+package http2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ClientPreface is what a client sends first on an HTTP/2 connection, before
+// its SETTINGS frame (RFC 9113 3.4).
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// frameType is the type of a frame (RFC 9113 6).
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+// Frame flags, their meaning depends on the frame type.
+const (
+	flagEndStream  uint8 = 0x1
+	flagAck        uint8 = 0x1
+	flagEndHeaders uint8 = 0x4
+	flagPadded     uint8 = 0x8
+	flagPriority   uint8 = 0x20
+)
+
+// Settings parameters (RFC 9113 6.5.2).
+const (
+	settingHeaderTableSize      uint16 = 0x1
+	settingEnablePush           uint16 = 0x2
+	settingMaxConcurrentStreams uint16 = 0x3
+	settingInitialWindowSize    uint16 = 0x4
+	settingMaxFrameSize         uint16 = 0x5
+	settingMaxHeaderListSize    uint16 = 0x6
+)
+
+const (
+	frameHeaderLen = 9
+	// defaultMaxFrameSize is the largest frame payload until the peer
+	// allows more, and the largest we accept.
+	defaultMaxFrameSize = 1 << 14
+	maxFrameSizeLimit   = 1<<24 - 1
+	// defaultWindowSize is the initial flow control window of the
+	// connection and of every stream (RFC 9113 6.9.2).
+	defaultWindowSize = 1<<16 - 1
+	maxWindowSize     = 1<<31 - 1
+	// defaultHeaderTableSize is the initial size of the HPACK dynamic
+	// table (RFC 7541 4.2).
+	defaultHeaderTableSize = 4096
+)
+
+// errCode is the error code of RST_STREAM and GOAWAY frames (RFC 9113 7).
+type errCode uint32
+
+const (
+	errCodeNo                 errCode = 0x0
+	errCodeProtocol           errCode = 0x1
+	errCodeInternal           errCode = 0x2
+	errCodeFlowControl        errCode = 0x3
+	errCodeSettingsTimeout    errCode = 0x4
+	errCodeStreamClosed       errCode = 0x5
+	errCodeFrameSize          errCode = 0x6
+	errCodeRefusedStream      errCode = 0x7
+	errCodeCancel             errCode = 0x8
+	errCodeCompression        errCode = 0x9
+	errCodeConnect            errCode = 0xa
+	errCodeEnhanceYourCalm    errCode = 0xb
+	errCodeInadequateSecurity errCode = 0xc
+	errCodeHTTP11Required     errCode = 0xd
+)
+
+var errCodeNames = map[errCode]string{
+	errCodeNo:                 "NO_ERROR",
+	errCodeProtocol:           "PROTOCOL_ERROR",
+	errCodeInternal:           "INTERNAL_ERROR",
+	errCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	errCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	errCodeStreamClosed:       "STREAM_CLOSED",
+	errCodeFrameSize:          "FRAME_SIZE_ERROR",
+	errCodeRefusedStream:      "REFUSED_STREAM",
+	errCodeCancel:             "CANCEL",
+	errCodeCompression:        "COMPRESSION_ERROR",
+	errCodeConnect:            "CONNECT_ERROR",
+	errCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	errCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	errCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c errCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown error code %#x", uint32(c))
+}
+
+// connError is a protocol violation that ends the whole connection with a
+// GOAWAY frame (RFC 9113 5.4.1).
+type connError struct {
+	code   errCode
+	reason string
+}
+
+func (e *connError) Error() string {
+	return fmt.Sprintf("http2: connection error: %v: %s", e.code, e.reason)
+}
+
+// streamError is a protocol violation that only ends one stream with a
+// RST_STREAM frame (RFC 9113 5.4.2).
+type streamError struct {
+	streamID uint32
+	code     errCode
+	reason   string
+}
+
+func (e *streamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error: %v: %s", e.streamID, e.code, e.reason)
+}
+
+// frame is a single HTTP/2 frame (RFC 9113 4.1).
+type frame struct {
+	typ      frameType
+	flags    uint8
+	streamID uint32
+	payload  []byte
+}
+
+func (f frame) has(flag uint8) bool {
+	return f.flags&flag != 0
+}
+
+// readFrame reads a frame from r. Payloads larger than maxSize are a
+// FRAME_SIZE_ERROR.
+func readFrame(r io.Reader, maxSize uint32) (frame, error) {
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	if length > maxSize {
+		return frame{}, &connError{code: errCodeFrameSize, reason: fmt.Sprintf("frame of %d bytes exceeds %d", length, maxSize)}
+	}
+	f := frame{
+		typ:   frameType(header[3]),
+		flags: header[4],
+		// The reserved bit is ignored.
+		streamID: binary.BigEndian.Uint32(header[5:]) & (1<<31 - 1),
+		payload:  make([]byte, length),
+	}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, unexpectedEOF(err)
+	}
+	return f, nil
+}
+
+// writeFrame writes a frame with payload to w.
+func writeFrame(w io.Writer, typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	header := [frameHeaderLen]byte{
+		byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)),
+		byte(typ), flags,
+	}
+	binary.BigEndian.PutUint32(header[5:], streamID)
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// unpad strips the padding of DATA and HEADERS frames with the PADDED flag
+// (RFC 9113 6.1).
+func unpad(f frame) ([]byte, error) {
+	if !f.has(flagPadded) {
+		return f.payload, nil
+	}
+	if len(f.payload) == 0 {
+		return nil, &connError{code: errCodeFrameSize, reason: "missing pad length"}
+	}
+	padLen := int(f.payload[0])
+	if padLen >= len(f.payload) {
+		return nil, &connError{code: errCodeProtocol, reason: "padding exceeds the payload"}
+	}
+	return f.payload[1 : len(f.payload)-padLen], nil
+}
+
+// setting is a single SETTINGS parameter.
+type setting struct {
+	id    uint16
+	value uint32
+}
+
+func settingsPayload(settings ...setting) []byte {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, s.id)
+		payload = binary.BigEndian.AppendUint32(payload, s.value)
+	}
+	return payload
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
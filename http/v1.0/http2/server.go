@@ -0,0 +1,833 @@
+// Package http2 implements the server side of HTTP/2 (RFC 9113) over a
+// connection that already started speaking it, such as HTTP/2 over cleartext
+// TCP with prior knowledge. Streams are multiplexed onto an http.Handler.
+//
+// Header compression uses golang.org/x/net/http2/hpack. Server push and
+// stream priorities are not implemented.
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	defaultMaxConcurrentStreams = 100
+	defaultMaxHeaderBytes       = 1 << 20
+	// goAwayTimeout is how long we keep reading after the last stream of a
+	// connection we sent GOAWAY on is done, so frames the client sent in the
+	// meantime don't turn our close into a reset that loses the responses.
+	goAwayTimeout = time.Second
+)
+
+// errConnClosed is returned by writes to streams of a closed connection.
+var errConnClosed = errors.New("http2: connection closed")
+
+// errStreamReset is returned by reads and writes on a stream that was reset.
+var errStreamReset = errors.New("http2: stream reset")
+
+// Server holds the configuration of HTTP/2 connections.
+type Server struct {
+	// MaxConcurrentStreams limits the number of streams a client may have
+	// open at once, further streams are refused. If zero, 100 is used.
+	MaxConcurrentStreams uint32
+	// MaxHeaderBytes limits the decoded size of a request's header list.
+	// Larger requests are answered with 431 Request Header Fields Too
+	// Large. If zero, 1MB is used.
+	MaxHeaderBytes int
+	// IdleTimeout closes a connection that had no open streams for this
+	// long, after sending GOAWAY. If zero, connections are kept open until
+	// the client closes them.
+	IdleTimeout time.Duration
+}
+
+// ServeConn serves HTTP/2 on conn, reading from r, which must start with the
+// client preface. Passing r rather than reading conn lets the caller peek at
+// the preface through a bufio.Reader.
+//
+// Each stream is handled by handler in its own goroutine, with a context
+// derived from ctx. Once ctx is done, the client is sent GOAWAY and the
+// connection is closed after the streams it already opened are done.
+// ServeConn closes conn and returns once all handlers have returned. A
+// client hanging up is not an error.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn, r io.Reader, handler http.Handler) error {
+	sc := &serverConn{
+		srv:               s,
+		conn:              conn,
+		r:                 r,
+		handler:           handler,
+		ctx:               context.WithValue(ctx, http.LocalAddrContextKey, conn.LocalAddr()),
+		dec:               hpack.NewDecoder(defaultHeaderTableSize, nil),
+		bw:                bufio.NewWriterSize(conn, defaultMaxFrameSize+frameHeaderLen),
+		streams:           make(map[uint32]*stream),
+		sendWindow:        defaultWindowSize,
+		recvWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+	}
+	sc.enc = hpack.NewEncoder(&sc.encBuf)
+	sc.dec.SetMaxStringLength(s.maxHeaderBytes())
+	sc.peerMaxFrameSize.Store(defaultMaxFrameSize)
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc.serve()
+}
+
+func (s *Server) maxConcurrentStreams() uint32 {
+	if s.MaxConcurrentStreams > 0 {
+		return s.MaxConcurrentStreams
+	}
+	return defaultMaxConcurrentStreams
+}
+
+func (s *Server) maxHeaderBytes() int {
+	if s.MaxHeaderBytes > 0 {
+		return s.MaxHeaderBytes
+	}
+	return defaultMaxHeaderBytes
+}
+
+// serverConn is the state of one connection. Frames are read by the serve
+// loop, handlers run in their own goroutines and write frames under
+// writeMu. Frames are never written while holding mu, a slow write would
+// hold up every stream.
+type serverConn struct {
+	srv     *Server
+	conn    net.Conn
+	r       io.Reader
+	handler http.Handler
+	ctx     context.Context
+
+	// dec is only used by the serve loop.
+	dec *hpack.Decoder
+	// headerBlock collects the fragments of a header block until
+	// END_HEADERS, no other frame may come in between (RFC 9113 6.10).
+	headerBlock *headerBlock
+
+	writeMu sync.Mutex
+	bw      *bufio.Writer
+	enc     *hpack.Encoder
+	encBuf  bytes.Buffer
+
+	// peerMaxFrameSize is the largest frame payload the client accepts.
+	peerMaxFrameSize atomic.Uint32
+
+	mu sync.Mutex
+	// cond is broadcast whenever a flow control window grows, request body
+	// data arrives or a stream or the connection is closed.
+	cond    *sync.Cond
+	streams map[uint32]*stream
+	// maxStreamID is the highest stream ID the client opened, streams with
+	// lower IDs that aren't in streams are closed.
+	maxStreamID uint32
+	// sendWindow is how much DATA we may send on the connection,
+	// recvWindow how much the client may.
+	sendWindow        int64
+	recvWindow        int64
+	peerInitialWindow int64
+	goingAway         bool
+	closed            bool
+	idleTimer         *time.Timer
+
+	wg sync.WaitGroup
+}
+
+type headerBlock struct {
+	streamID  uint32
+	endStream bool
+	data      []byte
+}
+
+func (sc *serverConn) serve() error {
+	defer sc.close()
+
+	preface := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(sc.r, preface); err != nil {
+		return err
+	}
+	if string(preface) != ClientPreface {
+		return fmt.Errorf("http2: invalid client preface %q", preface)
+	}
+	err := sc.writeFrame(frameSettings, 0, 0, settingsPayload(
+		setting{settingMaxConcurrentStreams, sc.srv.maxConcurrentStreams()},
+		setting{settingMaxHeaderListSize, uint32(sc.srv.maxHeaderBytes())},
+	))
+	if err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(sc.ctx, func() { sc.goAway(errCodeNo, "") })
+	defer stop()
+	sc.mu.Lock()
+	sc.startIdleTimerLocked()
+	sc.mu.Unlock()
+
+	for first := true; ; first = false {
+		f, err := readFrame(sc.r, defaultMaxFrameSize)
+		if err == nil && first && (f.typ != frameSettings || f.has(flagAck)) {
+			// The preface ends with the client's SETTINGS (RFC 9113 3.4).
+			err = &connError{code: errCodeProtocol, reason: "first frame is not SETTINGS"}
+		}
+		if err == nil {
+			err = sc.processFrame(f)
+		}
+		if err != nil {
+			return sc.readError(err)
+		}
+	}
+}
+
+// readError turns the error that ended the serve loop into the error
+// returned by ServeConn.
+func (sc *serverConn) readError(err error) error {
+	var connErr *connError
+	if errors.As(err, &connErr) {
+		sc.goAway(connErr.code, connErr.reason)
+		return err
+	}
+	sc.mu.Lock()
+	goingAway := sc.goingAway
+	sc.mu.Unlock()
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+		// The client hung up, or the server closed the connection.
+		return nil
+	case goingAway && errors.Is(err, os.ErrDeadlineExceeded):
+		return nil
+	}
+	return err
+}
+
+// close ends all streams and waits for their handlers to return.
+func (sc *serverConn) close() {
+	_ = sc.conn.Close()
+	sc.mu.Lock()
+	sc.closed = true
+	if sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+	}
+	for _, st := range sc.streams {
+		st.resetLocked(errConnClosed)
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	sc.wg.Wait()
+}
+
+func (sc *serverConn) processFrame(f frame) error {
+	if sc.headerBlock != nil && (f.typ != frameContinuation || f.streamID != sc.headerBlock.streamID) {
+		return &connError{code: errCodeProtocol, reason: fmt.Sprintf("frame type %d in the middle of a header block", f.typ)}
+	}
+
+	var err error
+	switch f.typ {
+	case frameData:
+		err = sc.processData(f)
+	case frameHeaders:
+		err = sc.processHeaders(f)
+	case frameContinuation:
+		err = sc.processContinuation(f)
+	case framePriority:
+		err = sc.processPriority(f)
+	case frameRSTStream:
+		err = sc.processRSTStream(f)
+	case frameSettings:
+		err = sc.processSettings(f)
+	case framePushPromise:
+		err = &connError{code: errCodeProtocol, reason: "PUSH_PROMISE from a client"}
+	case framePing:
+		err = sc.processPing(f)
+	case frameGoAway:
+		err = sc.processGoAway(f)
+	case frameWindowUpdate:
+		err = sc.processWindowUpdate(f)
+	default:
+		// Unknown frame types are ignored (RFC 9113 4.1).
+	}
+
+	var streamErr *streamError
+	if errors.As(err, &streamErr) {
+		return sc.resetStream(streamErr.streamID, streamErr.code)
+	}
+	return err
+}
+
+func (sc *serverConn) processData(f frame) error {
+	if f.streamID == 0 {
+		return &connError{code: errCodeProtocol, reason: "DATA on stream 0"}
+	}
+	data, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	credit, st, err := sc.receiveData(f.streamID, data, int64(len(f.payload)), f.has(flagEndStream))
+	if credit > 0 {
+		if wuErr := sc.writeWindowUpdate(0, credit); wuErr != nil {
+			return wuErr
+		}
+		if st != nil && !f.has(flagEndStream) {
+			if wuErr := sc.writeWindowUpdate(st.id, credit); wuErr != nil {
+				return wuErr
+			}
+		}
+	}
+	return err
+}
+
+// receiveData passes the data of a DATA frame of length bytes to its
+// stream's body. The whole frame counts against the flow control windows,
+// padding included (RFC 9113 6.9). It returns how much of the connection
+// window can be handed back right away, and of the window of st if it isn't
+// nil, since nobody will read it.
+func (sc *serverConn) receiveData(id uint32, data []byte, length int64, endStream bool) (int64, *stream, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if id > sc.maxStreamID {
+		return 0, nil, &connError{code: errCodeProtocol, reason: fmt.Sprintf("DATA on idle stream %d", id)}
+	}
+	if length > sc.recvWindow {
+		return 0, nil, &connError{code: errCodeFlowControl, reason: "DATA exceeds the connection window"}
+	}
+	st := sc.streams[id]
+	switch {
+	case st == nil || st.reset:
+		// Frames on streams we closed may still be in flight.
+		return length, nil, nil
+	case st.remoteDone:
+		return length, nil, &streamError{streamID: id, code: errCodeStreamClosed, reason: "DATA after END_STREAM"}
+	case length > st.recvWindow:
+		return length, nil, &streamError{streamID: id, code: errCodeFlowControl, reason: "DATA exceeds the stream window"}
+	}
+	if endStream {
+		st.remoteDone = true
+	}
+	if st.body.closed {
+		return length, nil, nil
+	}
+	if err := st.body.write(data, endStream); err != nil {
+		return length, nil, err
+	}
+	// The data is handed back once the handler read it.
+	sc.recvWindow -= int64(len(data))
+	st.recvWindow -= int64(len(data))
+	sc.cond.Broadcast()
+	return length - int64(len(data)), st, nil
+}
+
+func (sc *serverConn) processHeaders(f frame) error {
+	if f.streamID == 0 {
+		return &connError{code: errCodeProtocol, reason: "HEADERS on stream 0"}
+	}
+	if f.streamID%2 == 0 {
+		return &connError{code: errCodeProtocol, reason: fmt.Sprintf("HEADERS on server stream %d", f.streamID)}
+	}
+	fragment, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	if f.has(flagPriority) {
+		// Priorities are deprecated (RFC 9113 5.3.2), but the field is
+		// still sent by some clients.
+		if len(fragment) < 5 {
+			return &connError{code: errCodeFrameSize, reason: "HEADERS too short for priority"}
+		}
+		fragment = fragment[5:]
+	}
+	sc.headerBlock = &headerBlock{streamID: f.streamID, endStream: f.has(flagEndStream)}
+	return sc.addHeaderFragment(fragment, f.has(flagEndHeaders))
+}
+
+func (sc *serverConn) processContinuation(f frame) error {
+	if sc.headerBlock == nil {
+		return &connError{code: errCodeProtocol, reason: "CONTINUATION without HEADERS"}
+	}
+	return sc.addHeaderFragment(f.payload, f.has(flagEndHeaders))
+}
+
+func (sc *serverConn) addHeaderFragment(fragment []byte, endHeaders bool) error {
+	b := sc.headerBlock
+	// A compressed header block can't be much larger than the header list
+	// we accept, don't buffer endless CONTINUATION frames.
+	if len(b.data)+len(fragment) > 2*sc.srv.maxHeaderBytes() {
+		return &connError{code: errCodeEnhanceYourCalm, reason: "header block too large"}
+	}
+	b.data = append(b.data, fragment...)
+	if !endHeaders {
+		return nil
+	}
+	sc.headerBlock = nil
+	return sc.processHeaderBlock(b)
+}
+
+// processHeaderBlock opens a stream with a complete header block, or adds
+// the trailers of an open one.
+func (sc *serverConn) processHeaderBlock(b *headerBlock) error {
+	// The block is decoded even if we ignore it, the client's encoder has
+	// updated its dynamic table either way.
+	fields, truncated, err := sc.decodeHeaderBlock(b.data)
+	if err != nil {
+		return &connError{code: errCodeCompression, reason: err.Error()}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if st := sc.streams[b.streamID]; st != nil {
+		if truncated {
+			return &streamError{streamID: st.id, code: errCodeProtocol, reason: "trailers too large"}
+		}
+		return sc.processTrailersLocked(st, b, fields)
+	}
+	if b.streamID <= sc.maxStreamID {
+		// A stream we already closed, ignore what was in flight.
+		return nil
+	}
+	sc.maxStreamID = b.streamID
+	if sc.goingAway {
+		// Streams after GOAWAY are ignored, the client retries them on
+		// another connection (RFC 9113 6.8).
+		return nil
+	}
+	if uint32(len(sc.streams)) >= sc.srv.maxConcurrentStreams() {
+		return &streamError{streamID: b.streamID, code: errCodeRefusedStream, reason: "too many concurrent streams"}
+	}
+
+	handler := sc.handler
+	if truncated {
+		// Answered with what was kept, the pseudo-header fields come
+		// first.
+		handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+		})
+	}
+	req, err := newRequest(fields, b.endStream)
+	if err != nil {
+		return &streamError{streamID: b.streamID, code: errCodeProtocol, reason: err.Error()}
+	}
+	sc.startStreamLocked(b.streamID, req, handler, b.endStream)
+	return nil
+}
+
+// decodeHeaderBlock decodes a complete header block. Once the header list
+// grows past maxHeaderBytes the remaining fields are still decoded, to keep
+// the dynamic table in sync, but not kept: a small block of indexed fields
+// can expand enormously.
+func (sc *serverConn) decodeHeaderBlock(data []byte) (fields []hpack.HeaderField, truncated bool, err error) {
+	size := 0
+	sc.dec.SetEmitFunc(func(f hpack.HeaderField) {
+		// The size as defined for SETTINGS_MAX_HEADER_LIST_SIZE
+		// (RFC 9113 6.5.2).
+		size += len(f.Name) + len(f.Value) + 32
+		if size > sc.srv.maxHeaderBytes() {
+			truncated = true
+			return
+		}
+		fields = append(fields, f)
+	})
+	if _, err := sc.dec.Write(data); err != nil {
+		return nil, false, err
+	}
+	if err := sc.dec.Close(); err != nil {
+		return nil, false, err
+	}
+	return fields, truncated, nil
+}
+
+func (sc *serverConn) processTrailersLocked(st *stream, b *headerBlock, fields []hpack.HeaderField) error {
+	if st.remoteDone {
+		return &streamError{streamID: st.id, code: errCodeStreamClosed, reason: "HEADERS after END_STREAM"}
+	}
+	if !b.endStream {
+		return &streamError{streamID: st.id, code: errCodeProtocol, reason: "trailers without END_STREAM"}
+	}
+	trailer, err := newTrailer(fields)
+	if err != nil {
+		return &streamError{streamID: st.id, code: errCodeProtocol, reason: err.Error()}
+	}
+	if st.body != nil {
+		if err := st.body.write(nil, true); err != nil {
+			return err
+		}
+	}
+	if st.req.Trailer == nil {
+		st.req.Trailer = make(http.Header)
+	}
+	for k, vv := range trailer {
+		st.req.Trailer[k] = vv
+	}
+	st.remoteDone = true
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) startStreamLocked(id uint32, req *http.Request, handler http.Handler, endStream bool) {
+	ctx, cancel := context.WithCancel(sc.ctx)
+	st := &stream{
+		sc:         sc,
+		id:         id,
+		cancel:     cancel,
+		sendWindow: sc.peerInitialWindow,
+		recvWindow: defaultWindowSize,
+		remoteDone: endStream,
+	}
+	req.RemoteAddr = sc.conn.RemoteAddr().String()
+	st.req = req.WithContext(ctx)
+	if !endStream {
+		st.body = &requestBody{st: st, remaining: req.ContentLength}
+		st.req.Body = st.body
+	}
+	sc.streams[id] = st
+	if sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+	}
+
+	w := &responseWriter{st: st, req: st.req, header: make(http.Header)}
+	sc.wg.Add(1)
+	go sc.runHandler(st, w, handler)
+}
+
+func (sc *serverConn) runHandler(st *stream, w *responseWriter, handler http.Handler) {
+	defer sc.wg.Done()
+	handler.ServeHTTP(w, st.req)
+	_ = w.finish()
+	st.cancel()
+	if st.req.MultipartForm != nil {
+		_ = st.req.MultipartForm.RemoveAll()
+	}
+
+	sc.mu.Lock()
+	delete(sc.streams, st.id)
+	// The client may still be sending a body nobody reads, tell it to stop
+	// now that the response is complete (RFC 9113 8.1).
+	stopBody := !st.remoteDone && !st.reset
+	var unread int64
+	if st.body != nil {
+		unread = st.body.closeLocked()
+	}
+	sc.recvWindow += unread
+	sc.startIdleTimerLocked()
+	sc.closeIfDoneLocked()
+	sc.mu.Unlock()
+
+	if stopBody {
+		_ = sc.writeRSTStream(st.id, errCodeNo)
+	}
+	if unread > 0 {
+		_ = sc.writeWindowUpdate(0, unread)
+	}
+}
+
+func (sc *serverConn) processPriority(f frame) error {
+	if f.streamID == 0 {
+		return &connError{code: errCodeProtocol, reason: "PRIORITY on stream 0"}
+	}
+	if len(f.payload) != 5 {
+		return &streamError{streamID: f.streamID, code: errCodeFrameSize, reason: "PRIORITY must be 5 bytes"}
+	}
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f frame) error {
+	if f.streamID == 0 {
+		return &connError{code: errCodeProtocol, reason: "RST_STREAM on stream 0"}
+	}
+	if len(f.payload) != 4 {
+		return &connError{code: errCodeFrameSize, reason: "RST_STREAM must be 4 bytes"}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.streamID > sc.maxStreamID {
+		return &connError{code: errCodeProtocol, reason: fmt.Sprintf("RST_STREAM on idle stream %d", f.streamID)}
+	}
+	if st := sc.streams[f.streamID]; st != nil {
+		st.resetLocked(errStreamReset)
+		sc.cond.Broadcast()
+	}
+	return nil
+}
+
+func (sc *serverConn) processSettings(f frame) error {
+	if f.streamID != 0 {
+		return &connError{code: errCodeProtocol, reason: "SETTINGS on a stream"}
+	}
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return &connError{code: errCodeFrameSize, reason: "SETTINGS ACK with a payload"}
+		}
+		return nil
+	}
+	if len(f.payload)%6 != 0 {
+		return &connError{code: errCodeFrameSize, reason: "SETTINGS not a multiple of 6 bytes"}
+	}
+
+	for p := f.payload; len(p) > 0; p = p[6:] {
+		id, value := binary.BigEndian.Uint16(p), binary.BigEndian.Uint32(p[2:])
+		switch id {
+		case settingHeaderTableSize:
+			sc.writeMu.Lock()
+			sc.enc.SetMaxDynamicTableSizeLimit(value)
+			sc.writeMu.Unlock()
+		case settingEnablePush:
+			if value > 1 {
+				return &connError{code: errCodeProtocol, reason: "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case settingInitialWindowSize:
+			if value > maxWindowSize {
+				return &connError{code: errCodeFlowControl, reason: "SETTINGS_INITIAL_WINDOW_SIZE too large"}
+			}
+			if err := sc.setInitialWindow(int64(value)); err != nil {
+				return err
+			}
+		case settingMaxFrameSize:
+			if value < defaultMaxFrameSize || value > maxFrameSizeLimit {
+				return &connError{code: errCodeProtocol, reason: "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			sc.peerMaxFrameSize.Store(value)
+		default:
+			// Unknown settings and those that only limit what we
+			// send anyway are ignored.
+		}
+	}
+	return sc.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+// setInitialWindow changes the window of new streams, and adjusts the windows
+// of open ones by the difference (RFC 9113 6.9.2).
+func (sc *serverConn) setInitialWindow(size int64) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delta := size - sc.peerInitialWindow
+	sc.peerInitialWindow = size
+	for _, st := range sc.streams {
+		st.sendWindow += delta
+		if st.sendWindow > maxWindowSize {
+			return &connError{code: errCodeFlowControl, reason: "stream window overflow"}
+		}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processPing(f frame) error {
+	if f.streamID != 0 {
+		return &connError{code: errCodeProtocol, reason: "PING on a stream"}
+	}
+	if len(f.payload) != 8 {
+		return &connError{code: errCodeFrameSize, reason: "PING must be 8 bytes"}
+	}
+	if f.has(flagAck) {
+		return nil
+	}
+	return sc.writeFrame(framePing, flagAck, 0, f.payload)
+}
+
+func (sc *serverConn) processGoAway(f frame) error {
+	if f.streamID != 0 {
+		return &connError{code: errCodeProtocol, reason: "GOAWAY on a stream"}
+	}
+	if len(f.payload) < 8 {
+		return &connError{code: errCodeFrameSize, reason: "GOAWAY too short"}
+	}
+	// The client won't open more streams, finish the open ones and hang up.
+	sc.goAway(errCodeNo, "")
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f frame) error {
+	if len(f.payload) != 4 {
+		return &connError{code: errCodeFrameSize, reason: "WINDOW_UPDATE must be 4 bytes"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.payload) & (1<<31 - 1))
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.streamID == 0 {
+		if increment == 0 {
+			return &connError{code: errCodeProtocol, reason: "WINDOW_UPDATE of 0"}
+		}
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return &connError{code: errCodeFlowControl, reason: "connection window overflow"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	if f.streamID > sc.maxStreamID {
+		return &connError{code: errCodeProtocol, reason: fmt.Sprintf("WINDOW_UPDATE on idle stream %d", f.streamID)}
+	}
+	st := sc.streams[f.streamID]
+	if st == nil {
+		return nil
+	}
+	if increment == 0 {
+		return &streamError{streamID: f.streamID, code: errCodeProtocol, reason: "WINDOW_UPDATE of 0"}
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return &streamError{streamID: f.streamID, code: errCodeFlowControl, reason: "stream window overflow"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+// resetStream ends stream id with a RST_STREAM frame.
+func (sc *serverConn) resetStream(id uint32, code errCode) error {
+	sc.mu.Lock()
+	if st := sc.streams[id]; st != nil {
+		st.resetLocked(errStreamReset)
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+	return sc.writeRSTStream(id, code)
+}
+
+// goAway tells the client that no more streams are accepted. The connection
+// is closed after the open streams are done, right away for errors.
+func (sc *serverConn) goAway(code errCode, debug string) {
+	sc.mu.Lock()
+	if sc.goingAway && code == errCodeNo {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goingAway = true
+	lastStreamID := sc.maxStreamID
+	sc.closeIfDoneLocked()
+	sc.mu.Unlock()
+
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, debug...)
+	_ = sc.writeFrame(frameGoAway, 0, 0, payload)
+	if code != errCodeNo {
+		_ = sc.conn.Close()
+	}
+}
+
+// closeIfDoneLocked ends the serve loop once we sent GOAWAY and no stream
+// is left. The loop keeps reading for a moment to let the client see the
+// responses and close the connection itself.
+func (sc *serverConn) closeIfDoneLocked() {
+	if sc.goingAway && len(sc.streams) == 0 {
+		_ = sc.conn.SetReadDeadline(time.Now().Add(goAwayTimeout))
+	}
+}
+
+// startIdleTimerLocked starts the idle timeout if no stream is open.
+func (sc *serverConn) startIdleTimerLocked() {
+	if sc.srv.IdleTimeout <= 0 || len(sc.streams) > 0 || sc.closed {
+		return
+	}
+	if sc.idleTimer == nil {
+		sc.idleTimer = time.AfterFunc(sc.srv.IdleTimeout, func() { sc.goAway(errCodeNo, "idle timeout") })
+		return
+	}
+	sc.idleTimer.Reset(sc.srv.IdleTimeout)
+}
+
+// reserveWindow waits until DATA may be sent on st and takes up to n bytes
+// from the stream and connection windows.
+func (sc *serverConn) reserveWindow(st *stream, n int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for {
+		if st.reset {
+			return 0, st.err
+		}
+		if sc.closed {
+			return 0, errConnClosed
+		}
+		allowed := min(int64(n), st.sendWindow, sc.sendWindow, int64(sc.peerMaxFrameSize.Load()))
+		if allowed > 0 {
+			st.sendWindow -= allowed
+			sc.sendWindow -= allowed
+			return int(allowed), nil
+		}
+		sc.cond.Wait()
+	}
+}
+
+// consumed hands n bytes of the stream's and connection's receive windows
+// back to the client after the handler read them.
+func (sc *serverConn) consumed(st *stream, n int64) error {
+	sc.mu.Lock()
+	sc.recvWindow += n
+	updateStream := !st.remoteDone && !st.reset
+	if updateStream {
+		st.recvWindow += n
+	}
+	sc.mu.Unlock()
+
+	if err := sc.writeWindowUpdate(0, n); err != nil {
+		return err
+	}
+	if updateStream {
+		return sc.writeWindowUpdate(st.id, n)
+	}
+	return nil
+}
+
+func (sc *serverConn) writeWindowUpdate(streamID uint32, n int64) error {
+	return sc.writeFrame(frameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
+
+func (sc *serverConn) writeRSTStream(streamID uint32, code errCode) error {
+	return sc.writeFrame(frameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (sc *serverConn) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	if err := writeFrame(sc.bw, typ, flags, streamID, payload); err != nil {
+		return err
+	}
+	return sc.bw.Flush()
+}
+
+// writeHeaders encodes the response headers of a stream and writes them as
+// a HEADERS frame, followed by CONTINUATION frames if they don't fit.
+func (sc *serverConn) writeHeaders(streamID uint32, fields []hpack.HeaderField, endStream bool) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	// Encoding and writing must not be interleaved with another stream's,
+	// the client decodes blocks in the order they arrive.
+	sc.encBuf.Reset()
+	for _, f := range fields {
+		if err := sc.enc.WriteField(f); err != nil {
+			return err
+		}
+	}
+
+	block := sc.encBuf.Bytes()
+	maxSize := int(sc.peerMaxFrameSize.Load())
+	typ := frameHeaders
+	for {
+		n := min(len(block), maxSize)
+		var flags uint8
+		if typ == frameHeaders && endStream {
+			flags |= flagEndStream
+		}
+		if n == len(block) {
+			flags |= flagEndHeaders
+		}
+		if err := writeFrame(sc.bw, typ, flags, streamID, block[:n]); err != nil {
+			return err
+		}
+		block = block[n:]
+		if len(block) == 0 {
+			break
+		}
+		typ = frameContinuation
+	}
+	return sc.bw.Flush()
+}
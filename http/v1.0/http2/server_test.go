@@ -0,0 +1,581 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nethttp2 "golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// startServer serves HTTP/2 connections with srv and handler until the test
+// ends. Canceling the returned context makes the connections go away, the
+// errors of ServeConn are sent on the channel.
+func startServer(t *testing.T, srv *Server, handler http.Handler) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 16)
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		_ = l.Close()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- srv.ServeConn(ctx, conn, conn, handler)
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String(), cancel, errs
+}
+
+// newClient returns a client speaking HTTP/2 over cleartext TCP with prior
+// knowledge, counting its dials. Its connections are closed when the test
+// ends, so the server doesn't wait for them after GOAWAY.
+func newClient(t *testing.T, dials *atomic.Int32) *http.Client {
+	transport := &nethttp2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			dials.Add(1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return string(body)
+}
+
+func TestServeConn(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 512*1024) // 8MB
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+		_, _ = fmt.Fprintf(w, "hello %s %s", r.Method, r.Header.Get("Cookie"))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(large)
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	addr, _, _ := startServer(t, &Server{}, mux)
+	var dials atomic.Int32
+	client := newClient(t, &dials)
+
+	t.Run("get", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, addr+"/hello", nil)
+		req.Header.Add("Cookie", "a=1")
+		req.Header.Add("Cookie", "b=2")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if body := readBody(t, resp); body != "hello GET a=1; b=2" {
+			t.Fatalf("body = %q, want %q", body, "hello GET a=1; b=2")
+		}
+		if resp.ProtoMajor != 2 || resp.Header.Get("X-Proto") != "HTTP/2.0" {
+			t.Fatalf("proto = %s, server saw %q, want HTTP/2.0", resp.Proto, resp.Header.Get("X-Proto"))
+		}
+		if resp.ContentLength != 18 || resp.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+			t.Fatalf("content length = %d, type = %q, want 18 and sniffed text", resp.ContentLength, resp.Header.Get("Content-Type"))
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		resp, err := client.Head(addr + "/hello")
+		if err != nil {
+			t.Fatalf("head: %v", err)
+		}
+		if body := readBody(t, resp); body != "" || resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, body = %q, want 200 without body", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		resp, err := client.Get(addr + "/empty")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if body := readBody(t, resp); body != "" || resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("status = %d, body = %q, want 204 without body", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("large response", func(t *testing.T) {
+		// Larger than the client's windows, it has to send WINDOW_UPDATE.
+		resp, err := client.Get(addr + "/large")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if body := readBody(t, resp); body != string(large) {
+			t.Fatalf("body is %d bytes, want %d", len(body), len(large))
+		}
+	})
+
+	t.Run("large request body", func(t *testing.T) {
+		// Larger than our windows, we have to send WINDOW_UPDATE.
+		body := large[:1<<20]
+		resp, err := client.Post(addr+"/echo", "application/octet-stream", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		if got := readBody(t, resp); got != string(body) {
+			t.Fatalf("echoed %d bytes, want %d", len(got), len(body))
+		}
+	})
+
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("dialed %d times, want one connection for all requests", n)
+	}
+}
+
+func TestServeConnRequestTrailer(t *testing.T) {
+	addr, _, _ := startServer(t, &Server{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s %s", body, r.Trailer.Get("X-Checksum"))
+	}))
+	var dials atomic.Int32
+	req, _ := http.NewRequest(http.MethodPost, addr, io.NopCloser(strings.NewReader("data")))
+	req.Trailer = http.Header{"X-Checksum": {"abc"}}
+	resp, err := newClient(t, &dials).Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if body := readBody(t, resp); body != "data abc" {
+		t.Fatalf("body = %q, want %q", body, "data abc")
+	}
+}
+
+func TestServeConnMultiplexes(t *testing.T) {
+	// Every handler waits for all the others, which only works if the
+	// requests run at the same time on the one connection.
+	const n = 20
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	addr, _, _ := startServer(t, &Server{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		arrived.Wait()
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	var dials atomic.Int32
+	client := newClient(t, &dials)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path := fmt.Sprintf("/%d", i)
+			resp, err := client.Get(addr + path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer func() { _ = resp.Body.Close() }()
+			body, err := io.ReadAll(resp.Body)
+			if err == nil && string(body) != path {
+				err = fmt.Errorf("body = %q, want %q", body, path)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := dials.Load(); got != 1 {
+		t.Fatalf("dialed %d times, want 1", got)
+	}
+}
+
+func TestServeConnClientCancel(t *testing.T) {
+	canceled := make(chan struct{})
+	addr, _, _ := startServer(t, &Server{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	var dials atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+	resp, err := newClient(t, &dials).Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	// Canceling sends RST_STREAM, which cancels the handler's context.
+	cancel()
+	_ = resp.Body.Close()
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler context not canceled after RST_STREAM")
+	}
+}
+
+func TestServeConnGoAway(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	addr, shutdown, errs := startServer(t, &Server{}, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "done")
+	}))
+	var dials atomic.Int32
+	client := newClient(t, &dials)
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := client.Get(addr)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	// GOAWAY lets the open stream finish before the connection is closed.
+	shutdown()
+	close(release)
+	if r := <-results; r.err != nil || r.body != "done" {
+		t.Fatalf("body = %q, err = %v, want the in-flight response", r.body, r.err)
+	}
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("ServeConn: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed after GOAWAY")
+	}
+}
+
+func TestServeConnIdleTimeout(t *testing.T) {
+	addr, _, errs := startServer(t, &Server{IdleTimeout: 50 * time.Millisecond}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	var dials atomic.Int32
+	client := newClient(t, &dials)
+	resp, err := client.Get(addr)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	readBody(t, resp)
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("ServeConn: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle connection not closed")
+	}
+}
+
+// rawConn speaks HTTP/2 frames to the server directly, to send what a
+// well-behaved client wouldn't.
+type rawConn struct {
+	t      *testing.T
+	framer *nethttp2.Framer
+	enc    *hpack.Encoder
+	encBuf bytes.Buffer
+}
+
+func dialRaw(t *testing.T, addr string) *rawConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(addr, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, ClientPreface); err != nil {
+		t.Fatalf("write preface: %v", err)
+	}
+	c := &rawConn{t: t, framer: nethttp2.NewFramer(conn, conn)}
+	c.enc = hpack.NewEncoder(&c.encBuf)
+	return c
+}
+
+func (c *rawConn) headers(streamID uint32, endStream bool, fields ...string) {
+	c.t.Helper()
+	c.encBuf.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		_ = c.enc.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	err := c.framer.WriteHeaders(nethttp2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: c.encBuf.Bytes(),
+		EndStream:     endStream,
+		EndHeaders:    true,
+	})
+	if err != nil {
+		c.t.Fatalf("write headers: %v", err)
+	}
+}
+
+// waitFor reads frames until one matches, failing if the connection ends
+// first.
+func (c *rawConn) waitFor(match func(nethttp2.Frame) bool) nethttp2.Frame {
+	c.t.Helper()
+	for {
+		f, err := c.framer.ReadFrame()
+		if err != nil {
+			c.t.Fatalf("read frame: %v", err)
+		}
+		if match(f) {
+			return f
+		}
+	}
+}
+
+func (c *rawConn) waitForGoAway() *nethttp2.GoAwayFrame {
+	c.t.Helper()
+	return c.waitFor(func(f nethttp2.Frame) bool {
+		_, ok := f.(*nethttp2.GoAwayFrame)
+		return ok
+	}).(*nethttp2.GoAwayFrame)
+}
+
+func (c *rawConn) waitForStream(streamID uint32) nethttp2.Frame {
+	c.t.Helper()
+	return c.waitFor(func(f nethttp2.Frame) bool {
+		switch f.(type) {
+		case *nethttp2.MetaHeadersFrame, *nethttp2.HeadersFrame, *nethttp2.RSTStreamFrame:
+			return f.Header().StreamID == streamID
+		}
+		return false
+	})
+}
+
+func TestServeConnProtocolErrors(t *testing.T) {
+	addr, _, _ := startServer(t, &Server{MaxConcurrentStreams: 1}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			<-r.Context().Done()
+		}
+	}))
+
+	t.Run("first frame not SETTINGS", func(t *testing.T) {
+		c := dialRaw(t, addr)
+		_ = c.framer.WritePing(false, [8]byte{})
+		if f := c.waitForGoAway(); f.ErrCode != nethttp2.ErrCodeProtocol {
+			t.Fatalf("GOAWAY %v, want PROTOCOL_ERROR", f.ErrCode)
+		}
+	})
+
+	t.Run("frame too large", func(t *testing.T) {
+		c := dialRaw(t, addr)
+		_ = c.framer.WriteSettings()
+		_ = c.framer.WriteData(1, true, make([]byte, defaultMaxFrameSize+1))
+		if f := c.waitForGoAway(); f.ErrCode != nethttp2.ErrCodeFrameSize {
+			t.Fatalf("GOAWAY %v, want FRAME_SIZE_ERROR", f.ErrCode)
+		}
+	})
+
+	t.Run("even stream ID", func(t *testing.T) {
+		c := dialRaw(t, addr)
+		_ = c.framer.WriteSettings()
+		c.headers(2, true, ":method", "GET", ":scheme", "http", ":path", "/")
+		if f := c.waitForGoAway(); f.ErrCode != nethttp2.ErrCodeProtocol {
+			t.Fatalf("GOAWAY %v, want PROTOCOL_ERROR", f.ErrCode)
+		}
+	})
+
+	t.Run("malformed request", func(t *testing.T) {
+		c := dialRaw(t, addr)
+		_ = c.framer.WriteSettings()
+		c.headers(1, true, ":method", "GET", ":scheme", "http", ":path", "/", "Upper", "case")
+		f, ok := c.waitForStream(1).(*nethttp2.RSTStreamFrame)
+		if !ok || f.ErrCode != nethttp2.ErrCodeProtocol {
+			t.Fatalf("got %v, want RST_STREAM PROTOCOL_ERROR", f)
+		}
+		// Only the stream is reset, the connection is still usable.
+		c.headers(3, true, ":method", "GET", ":scheme", "http", ":path", "/")
+		if _, ok := c.waitForStream(3).(*nethttp2.HeadersFrame); !ok {
+			t.Fatal("no response on the next stream")
+		}
+	})
+
+	t.Run("too many streams", func(t *testing.T) {
+		c := dialRaw(t, addr)
+		_ = c.framer.WriteSettings()
+		c.headers(1, true, ":method", "GET", ":scheme", "http", ":path", "/block")
+		c.headers(3, true, ":method", "GET", ":scheme", "http", ":path", "/")
+		f, ok := c.waitForStream(3).(*nethttp2.RSTStreamFrame)
+		if !ok || f.ErrCode != nethttp2.ErrCodeRefusedStream {
+			t.Fatalf("got %v, want RST_STREAM REFUSED_STREAM", f)
+		}
+	})
+
+	t.Run("window overflow", func(t *testing.T) {
+		c := dialRaw(t, addr)
+		_ = c.framer.WriteSettings()
+		_ = c.framer.WriteWindowUpdate(0, maxWindowSize)
+		if f := c.waitForGoAway(); f.ErrCode != nethttp2.ErrCodeFlowControl {
+			t.Fatalf("GOAWAY %v, want FLOW_CONTROL_ERROR", f.ErrCode)
+		}
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		c := dialRaw(t, addr)
+		_ = c.framer.WriteSettings()
+		data := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+		_ = c.framer.WritePing(false, data)
+		f := c.waitFor(func(f nethttp2.Frame) bool {
+			ping, ok := f.(*nethttp2.PingFrame)
+			return ok && ping.IsAck()
+		}).(*nethttp2.PingFrame)
+		if f.Data != data {
+			t.Fatalf("PING ACK data = %v, want %v", f.Data, data)
+		}
+	})
+}
+
+func TestServeConnHeaderListTooLarge(t *testing.T) {
+	big := strings.Repeat("x", 1000)
+	addr, _, _ := startServer(t, &Server{MaxHeaderBytes: 16 << 10}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Big") != big {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	c := dialRaw(t, addr)
+	c.framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	_ = c.framer.WriteSettings()
+	status := func(streamID uint32) string {
+		t.Helper()
+		f, ok := c.waitForStream(streamID).(*nethttp2.MetaHeadersFrame)
+		if !ok {
+			t.Fatalf("got %v on stream %d, want HEADERS", f, streamID)
+		}
+		return f.PseudoValue("status")
+	}
+
+	// X-Big goes into the dynamic table, each one byte reference to it
+	// adds a kilobyte to the header list, 10 MB in all.
+	c.encBuf.Reset()
+	fields := []string{":method", "GET", ":scheme", "http", ":path", "/", "x-big", big}
+	for i := 0; i < len(fields); i += 2 {
+		_ = c.enc.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	// Index 62 is the newest dynamic table entry (RFC 7541 2.3.3).
+	block := append(c.encBuf.Bytes(), bytes.Repeat([]byte{0x80 | 62}, 10000)...)
+	err := c.framer.WriteHeaders(nethttp2.HeadersFrameParam{StreamID: 1, BlockFragment: block, EndStream: true, EndHeaders: true})
+	if err != nil {
+		t.Fatalf("write headers: %v", err)
+	}
+	if got := status(1); got != "431" {
+		t.Fatalf("status = %s, want 431", got)
+	}
+
+	// The dynamic table is still in sync, the next request refers to it.
+	c.headers(3, true, ":method", "GET", ":scheme", "http", ":path", "/", "x-big", big)
+	if got := status(3); got != "200" {
+		t.Fatalf("status = %s, want 200", got)
+	}
+}
+
+func TestDecodeHeaderBlock(t *testing.T) {
+	var buf bytes.Buffer
+	enc := hpack.NewEncoder(&buf)
+	_ = enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+	_ = enc.WriteField(hpack.HeaderField{Name: "x-big", Value: strings.Repeat("x", 1000)})
+	block := append(buf.Bytes(), bytes.Repeat([]byte{0x80 | 62}, 10000)...)
+
+	sc := &serverConn{srv: &Server{MaxHeaderBytes: 4096}, dec: hpack.NewDecoder(defaultHeaderTableSize, nil)}
+	fields, truncated, err := sc.decodeHeaderBlock(block)
+	if err != nil {
+		t.Fatalf("decodeHeaderBlock: %v", err)
+	}
+	if !truncated || len(fields) != 4 {
+		t.Fatalf("decodeHeaderBlock kept %d fields, truncated %t, want 4 and true", len(fields), truncated)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	fields := func(kv ...string) []hpack.HeaderField {
+		var fields []hpack.HeaderField
+		for i := 0; i < len(kv); i += 2 {
+			fields = append(fields, hpack.HeaderField{Name: kv[i], Value: kv[i+1]})
+		}
+		return fields
+	}
+	get := []string{":method", "GET", ":scheme", "https", ":authority", "example.com", ":path", "/a?b=c"}
+
+	req, err := newRequest(fields(append(get, "host", "other", "content-length", "3")...), false)
+	if err != nil {
+		t.Fatalf("newRequest: %v", err)
+	}
+	if req.Host != "example.com" || req.URL.Path != "/a" || req.URL.RawQuery != "b=c" || req.Header.Get("Host") != "" {
+		t.Fatalf("host = %q, url = %v, Host header = %q", req.Host, req.URL, req.Header.Get("Host"))
+	}
+	if req.ContentLength != 3 {
+		t.Fatalf("content length = %d, want 3", req.ContentLength)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		fields []string
+	}{
+		{name: "missing method", fields: []string{":scheme", "http", ":path", "/"}},
+		{name: "missing path", fields: []string{":method", "GET", ":scheme", "http"}},
+		{name: "unknown pseudo-header", fields: append(get, ":protocol", "websocket")},
+		{name: "pseudo-header after regular", fields: []string{":method", "GET", "accept", "*/*", ":scheme", "http", ":path", "/"}},
+		{name: "duplicate pseudo-header", fields: append(get, ":path", "/b")},
+		{name: "connection header", fields: append(get, "connection", "keep-alive")},
+		{name: "te", fields: append(get, "te", "gzip")},
+		{name: "CONNECT with path", fields: []string{":method", "CONNECT", ":authority", "example.com:443", ":path", "/"}},
+		{name: "invalid content length", fields: append(get, "content-length", "-1")},
+	} {
+		if _, err := newRequest(fields(tt.fields...), false); err == nil {
+			t.Errorf("%s: newRequest succeeded, want malformed request", tt.name)
+		}
+	}
+
+	if _, err := newRequest(fields(":method", "CONNECT", ":authority", "example.com:443"), false); err != nil {
+		t.Fatalf("CONNECT: %v", err)
+	}
+}
@@ -0,0 +1,461 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+// bufferedBodyBytes is how much of the body we hold back before sending the
+// headers. Responses that fit are sent with a Content-Length in a single
+// DATA frame.
+const bufferedBodyBytes = 4096
+
+// stream is a request and its response (RFC 9113 5.1). It is open while its
+// handler runs.
+type stream struct {
+	sc     *serverConn
+	id     uint32
+	req    *http.Request
+	cancel context.CancelFunc
+	// body is nil if the request has no body.
+	body *requestBody
+
+	// The remaining fields are guarded by sc.mu.
+	sendWindow int64
+	recvWindow int64
+	// remoteDone is set once the client sent END_STREAM.
+	remoteDone bool
+	// reset is set once the stream was reset by either side or the
+	// connection closed, err says which.
+	reset bool
+	err   error
+}
+
+func (st *stream) resetLocked(err error) {
+	if st.reset {
+		return
+	}
+	st.reset = true
+	st.err = err
+	st.cancel()
+	if st.body != nil && st.body.err == nil {
+		st.body.err = err
+	}
+}
+
+// writable returns an error if nothing may be sent on the stream anymore.
+func (st *stream) writable() error {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	if st.reset {
+		return st.err
+	}
+	if st.sc.closed {
+		return errConnClosed
+	}
+	return nil
+}
+
+// requestBody holds the DATA the client sent until the handler reads it. Its
+// fields are guarded by sc.mu.
+type requestBody struct {
+	st  *stream
+	buf bytes.Buffer
+	// err is returned once buf is drained, io.EOF after END_STREAM.
+	err    error
+	closed bool
+	// remaining is what is left of the Content-Length, or -1.
+	remaining int64
+}
+
+// write adds data received on the stream.
+func (b *requestBody) write(data []byte, endStream bool) error {
+	if b.remaining >= 0 {
+		if int64(len(data)) > b.remaining {
+			return &streamError{streamID: b.st.id, code: errCodeProtocol, reason: "body exceeds Content-Length"}
+		}
+		b.remaining -= int64(len(data))
+		if endStream && b.remaining > 0 {
+			return &streamError{streamID: b.st.id, code: errCodeProtocol, reason: "body shorter than Content-Length"}
+		}
+	}
+	b.buf.Write(data)
+	if endStream {
+		b.err = io.EOF
+	}
+	return nil
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	sc := b.st.sc
+	sc.mu.Lock()
+	for b.buf.Len() == 0 && b.err == nil && !b.closed {
+		sc.cond.Wait()
+	}
+	if b.closed {
+		sc.mu.Unlock()
+		return 0, errors.New("http2: read on closed body")
+	}
+	if b.buf.Len() == 0 {
+		err := b.err
+		sc.mu.Unlock()
+		return 0, err
+	}
+	n, _ := b.buf.Read(p)
+	sc.mu.Unlock()
+
+	// Let the client send more. If this fails, the connection is gone
+	// and the next read says so.
+	_ = sc.consumed(b.st, int64(n))
+	return n, nil
+}
+
+// Close discards the unread body. The client may still be sending it, the
+// connection window is handed back as the data arrives.
+func (b *requestBody) Close() error {
+	sc := b.st.sc
+	sc.mu.Lock()
+	unread := b.closeLocked()
+	sc.recvWindow += unread
+	sc.mu.Unlock()
+	if unread > 0 {
+		_ = sc.writeWindowUpdate(0, unread)
+	}
+	return nil
+}
+
+// closeLocked closes the body and returns how many buffered bytes were never
+// read.
+func (b *requestBody) closeLocked() int64 {
+	if b.closed {
+		return 0
+	}
+	b.closed = true
+	unread := int64(b.buf.Len())
+	b.buf.Reset()
+	b.st.sc.cond.Broadcast()
+	return unread
+}
+
+// responseWriter writes the response of a stream as a HEADERS frame followed
+// by DATA frames.
+type responseWriter struct {
+	st     *stream
+	req    *http.Request
+	header http.Header
+	// status is the code passed to WriteHeader. Like for HTTP/1.x, sending
+	// the headers is deferred until the buffer fills up or the handler
+	// returns, so we know the size of small bodies.
+	status     int
+	sentHeader bool
+	buf        []byte
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.sentHeader || w.status != 0 {
+		slog.Warn(fmt.Sprintf("WriteHeader called twice, second time with: %d", statusCode))
+		return
+	}
+	// Informational responses are sent right away. There is no 101
+	// Switching Protocols in HTTP/2 (RFC 9113 8.6).
+	if statusCode >= 100 && statusCode <= 199 {
+		if statusCode == http.StatusSwitchingProtocols {
+			slog.Warn("WriteHeader called with 101 on an HTTP/2 stream")
+			return
+		}
+		if err := w.writeHeaders(statusCode, false); err != nil {
+			slog.Error("failed to send informational response", "error", err)
+		}
+		return
+	}
+	w.status = statusCode
+}
+
+func (w *responseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !http1.BodyAllowed(w.statusCode()) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if w.req.Method == http.MethodHead {
+		return len(b), nil
+	}
+	if !w.sentHeader && len(w.buf)+len(b) <= bufferedBodyBytes {
+		w.buf = append(w.buf, b...)
+		return len(b), nil
+	}
+	if err := w.flush(false, b); err != nil {
+		return 0, err
+	}
+	if err := w.writeData(b, false); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush sends the headers and any buffered data to the client.
+func (w *responseWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// FlushError is like Flush but returns the error, it is preferred by
+// http.ResponseController.
+func (w *responseWriter) FlushError() error {
+	return w.flush(false, nil)
+}
+
+// finish ends the stream once the handler returned.
+func (w *responseWriter) finish() error {
+	if !w.sentHeader && w.req.Method != http.MethodHead && http1.BodyAllowed(w.statusCode()) && w.header.Get("Content-Length") == "" {
+		w.header.Set("Content-Length", strconv.Itoa(len(w.buf)))
+	}
+	return w.flush(true, nil)
+}
+
+// flush sends the headers if needed and the buffered body. next is the data
+// about to be written, used to sniff the content type of a body that exceeds
+// the buffer.
+func (w *responseWriter) flush(endStream bool, next []byte) error {
+	if !w.sentHeader {
+		w.sentHeader = true
+		w.prepareHeader(next)
+		if endStream && len(w.buf) == 0 {
+			return w.writeHeaders(w.statusCode(), true)
+		}
+		if err := w.writeHeaders(w.statusCode(), false); err != nil {
+			return err
+		}
+	}
+	body := w.buf
+	w.buf = nil
+	return w.writeData(body, endStream)
+}
+
+// prepareHeader sets the headers derived from the response before they are
+// sent.
+func (w *responseWriter) prepareHeader(next []byte) {
+	if _, ok := w.header["Date"]; !ok {
+		w.header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if !http1.BodyAllowed(w.statusCode()) {
+		// 1xx, 204 and 304 responses never have a body (RFC 9110 6.4.1).
+		if w.statusCode() != http.StatusNotModified {
+			w.header.Del("Content-Length")
+		}
+		return
+	}
+	// Like net/http, setting the Content-Type key to nil disables sniffing.
+	if _, ok := w.header["Content-Type"]; !ok && w.header.Get("Content-Encoding") == "" {
+		sample := append(w.buf[:len(w.buf):len(w.buf)], next...)
+		if len(sample) > 0 {
+			w.header.Set("Content-Type", http.DetectContentType(sample))
+		}
+	}
+}
+
+func (w *responseWriter) writeHeaders(statusCode int, endStream bool) error {
+	if err := w.st.writable(); err != nil {
+		return err
+	}
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(statusCode)}}
+	for key, values := range w.header {
+		name := strings.ToLower(key)
+		// Connection specific headers are not allowed, HTTP/2 has its own
+		// framing (RFC 9113 8.2.2).
+		if isConnectionHeader(name) {
+			continue
+		}
+		for _, v := range values {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	return w.st.sc.writeHeaders(w.st.id, fields, endStream)
+}
+
+// writeData sends p in DATA frames as the flow control windows allow.
+func (w *responseWriter) writeData(p []byte, endStream bool) error {
+	sc := w.st.sc
+	if len(p) == 0 {
+		if !endStream {
+			return nil
+		}
+		if err := w.st.writable(); err != nil {
+			return err
+		}
+		return sc.writeFrame(frameData, flagEndStream, w.st.id, nil)
+	}
+	for len(p) > 0 {
+		n, err := sc.reserveWindow(w.st, len(p))
+		if err != nil {
+			return err
+		}
+		var flags uint8
+		if endStream && n == len(p) {
+			flags = flagEndStream
+		}
+		if err := sc.writeFrame(frameData, flags, w.st.id, p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// newRequest builds the request of a stream from its header list. An error
+// means the request is malformed (RFC 9113 8.1.1).
+func newRequest(fields []hpack.HeaderField, endStream bool) (*http.Request, error) {
+	var method, scheme, authority, path string
+	header := make(http.Header)
+	for i, f := range fields {
+		if !strings.HasPrefix(f.Name, ":") {
+			if err := addField(header, f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Pseudo-headers come first and only once (RFC 9113 8.3).
+		if i > 0 && !strings.HasPrefix(fields[i-1].Name, ":") {
+			return nil, fmt.Errorf("pseudo-header %s after regular headers", f.Name)
+		}
+		var dst *string
+		switch f.Name {
+		case ":method":
+			dst = &method
+		case ":scheme":
+			dst = &scheme
+		case ":authority":
+			dst = &authority
+		case ":path":
+			dst = &path
+		default:
+			return nil, fmt.Errorf("unknown pseudo-header %s", f.Name)
+		}
+		if *dst != "" || f.Value == "" {
+			return nil, fmt.Errorf("duplicate or empty pseudo-header %s", f.Name)
+		}
+		*dst = f.Value
+	}
+	if method == "" {
+		return nil, errors.New("missing :method")
+	}
+
+	req := &http.Request{
+		Method:     method,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		ProtoMinor: 0,
+		Header:     header,
+		Host:       authority,
+		Body:       http.NoBody,
+	}
+	if method == http.MethodConnect {
+		// CONNECT names the host to tunnel to, and nothing else
+		// (RFC 9113 8.5).
+		if scheme != "" || path != "" || authority == "" {
+			return nil, errors.New("CONNECT must only have :method and :authority")
+		}
+		req.URL = &url.URL{Host: authority}
+		req.RequestURI = authority
+	} else {
+		if scheme == "" || path == "" {
+			return nil, errors.New("missing :scheme or :path")
+		}
+		if path == "*" && method != http.MethodOptions {
+			return nil, errors.New("path * is only allowed for OPTIONS")
+		}
+		u, err := url.ParseRequestURI(path)
+		if err != nil {
+			return nil, err
+		}
+		req.URL = u
+		req.RequestURI = path
+	}
+	if req.Host == "" {
+		req.Host = header.Get("Host")
+	}
+	// Like net/http, the Host header is only available as req.Host.
+	header.Del("Host")
+
+	// Cookies may be split into several fields for better compression
+	// (RFC 9113 8.2.3).
+	if cookies := header.Values("Cookie"); len(cookies) > 1 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+
+	req.ContentLength = -1
+	if endStream {
+		req.ContentLength = 0
+	} else if cl := header.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid content length %q", cl)
+		}
+		req.ContentLength = n
+	}
+	return req, nil
+}
+
+// newTrailer builds the trailer of a request from a header list that ends
+// the stream.
+func newTrailer(fields []hpack.HeaderField) (http.Header, error) {
+	trailer := make(http.Header)
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			return nil, fmt.Errorf("pseudo-header %s in trailers", f.Name)
+		}
+		if err := addField(trailer, f); err != nil {
+			return nil, err
+		}
+	}
+	return trailer, nil
+}
+
+// addField validates a regular header field and adds it to h. Names must be
+// lowercase and connection specific fields are not allowed (RFC 9113 8.2).
+func addField(h http.Header, f hpack.HeaderField) error {
+	if !httpguts.ValidHeaderFieldName(f.Name) || strings.ToLower(f.Name) != f.Name {
+		return fmt.Errorf("invalid header field name %q", f.Name)
+	}
+	if !httpguts.ValidHeaderFieldValue(f.Value) {
+		return fmt.Errorf("invalid value of header field %s", f.Name)
+	}
+	if isConnectionHeader(f.Name) {
+		return fmt.Errorf("connection specific header field %s", f.Name)
+	}
+	if f.Name == "te" && f.Value != "trailers" {
+		return fmt.Errorf("te header field with %q", f.Value)
+	}
+	h.Add(f.Name, f.Value)
+	return nil
+}
+
+func isConnectionHeader(name string) bool {
+	switch name {
+	case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+		return true
+	}
+	return false
+}
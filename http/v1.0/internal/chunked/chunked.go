@@ -0,0 +1,197 @@
+// Package chunked implements the chunked transfer coding (RFC 9112 7.1),
+// shared by the server and the client.
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+)
+
+// maxChunkLineBytes limits the size of a chunk size line, including any
+// chunk extensions.
+const maxChunkLineBytes = 4096
+
+// maxTrailerBytes limits the size of the trailer section.
+const maxTrailerBytes = 16 << 10
+
+var (
+	errChunkLineTooLong = errors.New("chunk size line too long")
+	errTrailerTooLong   = errors.New("trailer section too long")
+)
+
+// Reader decodes a body sent with "Transfer-Encoding: chunked". Trailer
+// fields following the last chunk are added to the trailer header once the
+// body has been read to EOF.
+type Reader struct {
+	r       *bufio.Reader
+	trailer http.Header
+	// n is the number of bytes left in the current chunk.
+	n int64
+	// started is set once the first chunk size line has been read, from
+	// then on every chunk is followed by a CRLF.
+	started bool
+	err     error
+}
+
+// NewReader returns a Reader that decodes the chunked body read from r and
+// fills in trailer. Nothing past the end of the body is consumed from r.
+func NewReader(r *bufio.Reader, trailer http.Header) *Reader {
+	return &Reader{r: r, trailer: trailer}
+}
+
+func (c *Reader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.n == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+// nextChunk reads the size line of the next chunk. It returns io.EOF after
+// reading the last chunk and the trailer section.
+func (c *Reader) nextChunk() error {
+	if c.started {
+		if err := c.readCRLF(); err != nil {
+			return err
+		}
+	}
+	c.started = true
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	// Chunk extensions are allowed after a semicolon, we ignore them.
+	size, _, _ := bytes.Cut(line, []byte{';'})
+	size = bytes.TrimRight(size, " \t")
+	// Only hex digits, ParseInt would also accept a sign.
+	if len(size) == 0 || len(bytes.TrimLeft(size, "0123456789abcdefABCDEF")) != 0 {
+		return fmt.Errorf("invalid chunk size %q", size)
+	}
+	c.n, err = strconv.ParseInt(string(size), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chunk size %q", size)
+	}
+	if c.n > 0 {
+		return nil
+	}
+
+	// The last chunk is followed by an optional trailer section.
+	if err := c.readTrailer(); err != nil {
+		return fmt.Errorf("read trailer: %w", err)
+	}
+	return io.EOF
+}
+
+// readTrailer reads the trailer section up to the empty line ending it and
+// adds its fields to the trailer header. The size is limited, since the
+// trailer arrives after the limits on the request header no longer apply.
+func (c *Reader) readTrailer() error {
+	var section []byte
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		section = append(append(section, line...), "\r\n"...)
+		if len(section) > maxTrailerBytes {
+			return errTrailerTooLong
+		}
+		if len(line) == 0 {
+			break
+		}
+	}
+	trailer, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(section))).ReadMIMEHeader()
+	if err != nil {
+		return err
+	}
+	for k, vals := range trailer {
+		for _, v := range vals {
+			c.trailer.Add(k, v)
+		}
+	}
+	return nil
+}
+
+func (c *Reader) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > maxChunkLineBytes {
+		return nil, errChunkLineTooLong
+	}
+	if errors.Is(err, io.EOF) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r")), nil
+}
+
+// readCRLF reads the CRLF after chunk data. Unlike in size lines, a bare LF
+// is rejected like net/http does, since a proxy in front of us might not
+// accept it and see a different body.
+func (c *Reader) readCRLF() error {
+	var crlf [2]byte
+	if _, err := io.ReadFull(c.r, crlf[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if string(crlf[:]) != "\r\n" {
+		return errors.New("malformed chunk: missing CRLF after chunk data")
+	}
+	return nil
+}
+
+// Writer encodes everything written to it as chunks. Close writes the last
+// chunk, it does not close the underlying writer.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a Writer that writes chunks to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (c *Writer) Write(b []byte) (int, error) {
+	// A zero sized chunk would terminate the body.
+	if len(b) == 0 {
+		return 0, nil
+	}
+	bufs := net.Buffers{
+		fmt.Appendf(nil, "%x\r\n", len(b)),
+		b,
+		[]byte("\r\n"),
+	}
+	if _, err := bufs.WriteTo(c.w); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *Writer) Close() error {
+	_, err := io.WriteString(c.w, "0\r\n\r\n")
+	return err
+}
@@ -0,0 +1,118 @@
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		raw         string
+		want        string
+		wantTrailer http.Header
+		wantErr     bool
+	}{
+		{
+			name:        "single chunk",
+			raw:         "5\r\nhello\r\n0\r\n\r\n",
+			want:        "hello",
+			wantTrailer: http.Header{},
+		},
+		{
+			name:        "multiple chunks with extensions",
+			raw:         "5;foo=bar\r\nhello\r\n6\r\n world\r\n0\r\n\r\n",
+			want:        "hello world",
+			wantTrailer: http.Header{},
+		},
+		{
+			name:        "trailers",
+			raw:         "3\r\nabc\r\n0\r\nExpires: never\r\nX-Checksum: 42\r\n\r\n",
+			want:        "abc",
+			wantTrailer: http.Header{"Expires": {"never"}, "X-Checksum": {"42"}},
+		},
+		{
+			name:    "invalid size",
+			raw:     "zz\r\nhello\r\n0\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "signed size",
+			raw:     "+5\r\nhello\r\n0\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty size",
+			raw:     "\r\nhello\r\n0\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing crlf after data",
+			raw:     "3\r\nabcdef\r\n0\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "bare lf after data",
+			raw:     "3\r\nabc\n0\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "trailer too long",
+			raw:     "0\r\n" + strings.Repeat("X-Padding: 0123456789\r\n", 1000) + "\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			raw:     "5\r\nhel",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			trailer := make(http.Header)
+			cr := NewReader(bufio.NewReader(strings.NewReader(tt.raw)), trailer)
+			got, err := io.ReadAll(cr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got body %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("body = %q, want %q", got, tt.want)
+			}
+			if len(trailer) != len(tt.wantTrailer) {
+				t.Fatalf("trailer = %v, want %v", trailer, tt.wantTrailer)
+			}
+			for k := range tt.wantTrailer {
+				if trailer.Get(k) != tt.wantTrailer.Get(k) {
+					t.Fatalf("trailer[%s] = %q, want %q", k, trailer.Get(k), tt.wantTrailer.Get(k))
+				}
+			}
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewWriter(&buf)
+	for _, s := range []string{"hello", "", " world"} {
+		if _, err := io.WriteString(cw, s); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	want := "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"
+	if buf.String() != want {
+		t.Fatalf("encoded = %q, want %q", buf.String(), want)
+	}
+}
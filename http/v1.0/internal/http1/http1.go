@@ -0,0 +1,99 @@
+// Package http1 implements the rules for HTTP/1 messages (RFC 9110 and RFC
+// 9112) shared by the server, the client and the WebSocket handshake.
+package http1
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// HasToken reports whether the comma separated header contains token,
+// ignoring case.
+func HasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidToken reports whether s is a non-empty token as defined by RFC 9110
+// 5.6.2, used for methods and header names.
+func ValidToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := range len(s) {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// ParseContentLength parses the Content-Length header values. Repeated
+// values are allowed as long as they are identical (RFC 9110 8.6). It
+// returns -1 without values.
+func ParseContentLength(values []string) (int64, error) {
+	if len(values) == 0 {
+		return -1, nil
+	}
+	var lengths []string
+	for _, v := range values {
+		for _, l := range strings.Split(v, ",") {
+			lengths = append(lengths, strings.TrimSpace(l))
+		}
+	}
+	for _, l := range lengths[1:] {
+		if l != lengths[0] {
+			return 0, fmt.Errorf("conflicting content lengths %q", values)
+		}
+	}
+	// Only digits, ParseInt would also accept a sign.
+	if lengths[0] == "" || strings.TrimLeft(lengths[0], "0123456789") != "" {
+		return 0, fmt.Errorf("invalid content length %q", lengths[0])
+	}
+	return strconv.ParseInt(lengths[0], 10, 64)
+}
+
+// ParseTrailerKeys returns a header with the keys announced in the "Trailer"
+// header and nil values, the values are filled in once the body is read.
+// Like net/http, it removes the "Trailer" header from h.
+func ParseTrailerKeys(h http.Header) http.Header {
+	trailer := make(http.Header)
+	for _, v := range h.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				trailer[textproto.CanonicalMIMEHeaderKey(k)] = nil
+			}
+		}
+	}
+	h.Del("Trailer")
+	return trailer
+}
+
+// BodyAllowed reports whether a response with the given status may include a
+// body (RFC 9110 6.4.1).
+func BodyAllowed(statusCode int) bool {
+	switch {
+	case statusCode >= 100 && statusCode <= 199:
+		return false
+	case statusCode == http.StatusNoContent, statusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package http1
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseContentLength(t *testing.T) {
+	for _, tt := range []struct {
+		values  []string
+		want    int64
+		wantErr bool
+	}{
+		{values: nil, want: -1},
+		{values: []string{"42"}, want: 42},
+		{values: []string{"3, 3", "3"}, want: 3},
+		{values: []string{"1", "2"}, wantErr: true},
+		{values: []string{"+1"}, wantErr: true},
+		{values: []string{""}, wantErr: true},
+		{values: []string{"99999999999999999999"}, wantErr: true},
+	} {
+		got, err := ParseContentLength(tt.values)
+		if (err != nil) != tt.wantErr || !tt.wantErr && got != tt.want {
+			t.Errorf("ParseContentLength(%q) = %d, %v, want %d", tt.values, got, err, tt.want)
+		}
+	}
+}
+
+func TestHasToken(t *testing.T) {
+	h := http.Header{"Connection": {"keep-alive, Upgrade", "x"}}
+	for token, want := range map[string]bool{"upgrade": true, "keep-alive": true, "x": true, "close": false, "Up": false} {
+		if got := HasToken(h, "Connection", token); got != want {
+			t.Errorf("HasToken(%q) = %t, want %t", token, got, want)
+		}
+	}
+}
+
+func TestParseTrailerKeys(t *testing.T) {
+	h := http.Header{"Trailer": {"expires, X-Checksum", " "}}
+	want := http.Header{"Expires": nil, "X-Checksum": nil}
+	if got := ParseTrailerKeys(h); !reflect.DeepEqual(got, want) {
+		t.Fatalf("trailer = %v, want %v", got, want)
+	}
+	if _, ok := h["Trailer"]; ok {
+		t.Fatal("Trailer header was kept")
+	}
+}
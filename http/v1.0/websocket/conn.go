@@ -0,0 +1,241 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	// TextMessage is a UTF-8 encoded text message.
+	TextMessage MessageType = MessageType(opText)
+	// BinaryMessage is a binary data message.
+	BinaryMessage MessageType = MessageType(opBinary)
+)
+
+// StatusCode is the status code of a close frame (RFC 6455 7.4.1).
+type StatusCode uint16
+
+const (
+	StatusNormalClosure      StatusCode = 1000
+	StatusGoingAway          StatusCode = 1001
+	StatusProtocolError      StatusCode = 1002
+	StatusUnsupportedData    StatusCode = 1003
+	StatusNoStatusReceived   StatusCode = 1005
+	StatusInvalidPayloadData StatusCode = 1007
+	StatusPolicyViolation    StatusCode = 1008
+	StatusMessageTooBig      StatusCode = 1009
+	StatusInternalError      StatusCode = 1011
+)
+
+// DefaultReadLimit is the default maximum size of a message.
+const DefaultReadLimit = 16 * 1024 * 1024
+
+// CloseError is returned by ReadMessage once the peer closed the
+// connection.
+type CloseError struct {
+	Code   StatusCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// ErrCloseSent is returned when writing a message after the close frame was
+// sent.
+var ErrCloseSent = errors.New("websocket: close sent")
+
+var errMessageTooBig = &closeReason{code: StatusMessageTooBig, text: "message too big"}
+
+// closeReason is a protocol violation by the peer. The connection is closed
+// with code.
+type closeReason struct {
+	code StatusCode
+	text string
+}
+
+func (e *closeReason) Error() string {
+	return "websocket: " + e.text
+}
+
+func protocolError(text string) error {
+	return &closeReason{code: StatusProtocolError, text: text}
+}
+
+// Conn is a WebSocket connection. One goroutine may read messages while
+// others write them.
+type Conn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isClient bool
+	limit    int64
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, isClient bool) *Conn {
+	return &Conn{
+		conn:     conn,
+		br:       br,
+		isClient: isClient,
+		limit:    DefaultReadLimit,
+	}
+}
+
+// SetReadLimit sets the maximum size of a message read from the peer. Larger
+// messages close the connection with StatusMessageTooBig.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.limit = limit
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage reads the next data message, reassembling fragmented ones.
+// Pings are answered while waiting. Once the peer sends a close frame, it is
+// answered and a *CloseError is returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		typ     MessageType
+		message []byte
+		reading bool
+	)
+	for {
+		// Servers read masked frames from clients and vice versa.
+		f, err := readFrame(c.br, !c.isClient, c.limit-int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.writeControl(opPong, f.payload); err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if reading {
+				return 0, nil, c.fail(protocolError("new message before the previous one finished"))
+			}
+			typ = MessageType(f.opcode)
+			reading = true
+		case opContinuation:
+			if !reading {
+				return 0, nil, c.fail(protocolError("continuation frame without a message"))
+			}
+		}
+
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if typ == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(&closeReason{code: StatusInvalidPayloadData, text: "invalid UTF-8 in text message"})
+		}
+		return typ, message, nil
+	}
+}
+
+// WriteMessage sends data as a single frame.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	return c.write(frame{fin: true, opcode: opcode(typ), payload: data})
+}
+
+// Ping sends a ping, the peer answers with a pong carrying the same data.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// WriteClose starts the closing handshake. Keep reading until ReadMessage
+// returns the peer's *CloseError, then call Close.
+func (c *Conn) WriteClose(code StatusCode, reason string) error {
+	return c.writeControl(opClose, closePayload(code, reason))
+}
+
+// Close closes the underlying connection without a closing handshake.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) writeControl(op opcode, payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return errors.New("websocket: control frame payload too large")
+	}
+	return c.write(frame{fin: true, opcode: op, payload: payload})
+}
+
+func (c *Conn) write(f frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if f.opcode == opClose {
+		c.closeSent = true
+	}
+	// Clients mask their frames, servers don't.
+	return writeFrame(c.conn, f, c.isClient)
+}
+
+// handleClose answers a close frame from the peer, unless we started the
+// closing handshake.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: StatusNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(protocolError("invalid close frame payload"))
+	case len(payload) >= 2:
+		closeErr.Code = StatusCode(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(&closeReason{code: StatusInvalidPayloadData, text: "invalid UTF-8 in close reason"})
+		}
+	}
+
+	var reply []byte
+	if closeErr.Code != StatusNoStatusReceived {
+		reply = closePayload(closeErr.Code, "")
+	}
+	if err := c.writeControl(opClose, reply); err != nil && !errors.Is(err, ErrCloseSent) {
+		return err
+	}
+	return closeErr
+}
+
+// fail closes the connection with the status of a protocol violation by the
+// peer, and returns err.
+func (c *Conn) fail(err error) error {
+	var reason *closeReason
+	if errors.As(err, &reason) {
+		_ = c.WriteClose(reason.code, reason.text)
+		_ = c.Close()
+	}
+	return err
+}
+
+func closePayload(code StatusCode, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
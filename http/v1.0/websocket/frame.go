@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// opcode is the frame type (RFC 6455 5.2).
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xa
+)
+
+func (op opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+// maxControlPayload is the largest payload allowed in a control frame
+// (RFC 6455 5.5).
+const maxControlPayload = 125
+
+// frame is a single WebSocket frame with an unmasked payload.
+type frame struct {
+	fin     bool
+	opcode  opcode
+	payload []byte
+}
+
+// readFrame reads a frame from r. Frames sent by clients must be masked,
+// frames sent by servers must not be (RFC 6455 5.1). Payloads larger than
+// limit are rejected with errMessageTooBig.
+func readFrame(r io.Reader, wantMasked bool, limit int64) (frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		fin:    header[0]&0x80 != 0,
+		opcode: opcode(header[0] & 0x0f),
+	}
+	if header[0]&0x70 != 0 {
+		return frame{}, protocolError("reserved bits set without a negotiated extension")
+	}
+	switch f.opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return frame{}, protocolError(fmt.Sprintf("unknown opcode %#x", f.opcode))
+	}
+
+	masked := header[1]&0x80 != 0
+	if masked != wantMasked {
+		return frame{}, protocolError(fmt.Sprintf("frame masked: %t, want %t", masked, wantMasked))
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame{}, unexpectedEOF(err)
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame{}, unexpectedEOF(err)
+		}
+		n := binary.BigEndian.Uint64(ext[:])
+		if n > 1<<63-1 {
+			return frame{}, protocolError("payload length has the most significant bit set")
+		}
+		length = int64(n)
+	}
+
+	if f.opcode.isControl() {
+		if !f.fin {
+			return frame{}, protocolError("fragmented control frame")
+		}
+		if length > maxControlPayload {
+			return frame{}, protocolError("control frame payload too large")
+		}
+	}
+	if length > limit {
+		return frame{}, errMessageTooBig
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return frame{}, unexpectedEOF(err)
+		}
+	}
+
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, unexpectedEOF(err)
+	}
+	if masked {
+		maskBytes(key, f.payload)
+	}
+	return f, nil
+}
+
+// writeFrame writes f to w, masking the payload with a random key if masked
+// is set. The payload of f is left untouched.
+func writeFrame(w io.Writer, f frame, masked bool) error {
+	buf := make([]byte, 0, 14+len(f.payload))
+
+	b0 := byte(f.opcode)
+	if f.fin {
+		b0 |= 0x80
+	}
+	buf = append(buf, b0)
+
+	var b1 byte
+	if masked {
+		b1 = 0x80
+	}
+	switch n := len(f.payload); {
+	case n <= 125:
+		buf = append(buf, b1|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, b1|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, b1|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if !masked {
+		buf = append(buf, f.payload...)
+		_, err := w.Write(buf)
+		return err
+	}
+
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	buf = append(buf, key[:]...)
+	start := len(buf)
+	buf = append(buf, f.payload...)
+	maskBytes(key, buf[start:])
+	_, err := w.Write(buf)
+	return err
+}
+
+// maskBytes applies the masking key to b in place. Masking and unmasking are
+// the same operation (RFC 6455 5.3).
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
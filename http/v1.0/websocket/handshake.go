@@ -0,0 +1,174 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) on top of
+// connections hijacked from an http.Handler, as well as a small client.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // mandated by RFC 6455 4.2.2, not used for security
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/http/v1.0/internal/http1"
+)
+
+// keyGUID is appended to Sec-WebSocket-Key to compute Sec-WebSocket-Accept
+// (RFC 6455 1.3).
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Upgrade validates the opening handshake in r, hijacks the connection and
+// replies with 101 Switching Protocols. If the handshake is invalid, an
+// error response is written and the error is returned.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if err := checkHandshake(w, r); err != nil {
+		return nil, err
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket: connection can't be hijacked", http.StatusInternalServerError)
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+
+	// Write the response ourselves so it looks the same on any server.
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return newConn(conn, brw.Reader, false), nil
+}
+
+// checkHandshake validates a client's opening handshake (RFC 6455 4.2.1).
+func checkHandshake(w http.ResponseWriter, r *http.Request) error {
+	fail := func(status int, msg string) error {
+		http.Error(w, msg, status)
+		return errors.New("websocket: " + msg)
+	}
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "method must be GET")
+	}
+	if !r.ProtoAtLeast(1, 1) {
+		return fail(http.StatusBadRequest, "HTTP/1.1 or later required")
+	}
+	if !http1.HasToken(r.Header, "Connection", "upgrade") {
+		return fail(http.StatusBadRequest, "missing Connection: Upgrade")
+	}
+	if !http1.HasToken(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "missing Upgrade: websocket")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported Sec-WebSocket-Version")
+	}
+	key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	return nil
+}
+
+// Dial opens a WebSocket connection to a ws:// or wss:// URL.
+func Dial(ctx context.Context, rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", hostPort(u, "80"))
+	case "wss":
+		var d tls.Dialer
+		conn, err = d.DialContext(ctx, "tcp", hostPort(u, "443"))
+	default:
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := clientHandshake(ctx, conn, u)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func clientHandshake(ctx context.Context, conn net.Conn, u *url.URL) (*Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+
+	rawKey := make([]byte, 16)
+	if _, err := rand.Read(rawKey); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(rawKey)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Host:       u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-Websocket-Key":     {key},
+			"Sec-Websocket-Version": {"13"},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket: handshake failed with status %s", resp.Status)
+	}
+	if !http1.HasToken(resp.Header, "Upgrade", "websocket") || !http1.HasToken(resp.Header, "Connection", "upgrade") {
+		return nil, errors.New("websocket: server did not upgrade the connection")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errors.New("websocket: invalid Sec-WebSocket-Accept")
+	}
+	return newConn(conn, br, true), nil
+}
+
+// acceptKey computes Sec-WebSocket-Accept for a Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + keyGUID)) //nolint:gosec // see import
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455 1.3.
+	if got, want := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Fatalf("acceptKey = %q, want %q", got, want)
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 125, 126, 0xffff, 0x10000} {
+		for _, masked := range []bool{false, true} {
+			payload := bytes.Repeat([]byte{'x'}, size)
+			var buf bytes.Buffer
+			if err := writeFrame(&buf, frame{fin: true, opcode: opBinary, payload: payload}, masked); err != nil {
+				t.Fatalf("write frame: %v", err)
+			}
+			f, err := readFrame(&buf, masked, DefaultReadLimit)
+			if err != nil {
+				t.Fatalf("read frame of %d bytes (masked: %t): %v", size, masked, err)
+			}
+			if !f.fin || f.opcode != opBinary || !bytes.Equal(f.payload, payload) {
+				t.Fatalf("frame of %d bytes (masked: %t) did not round trip", size, masked)
+			}
+		}
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		raw    []byte
+		masked bool
+	}{
+		{name: "reserved bits", raw: []byte{0xc1, 0x00}},
+		{name: "unknown opcode", raw: []byte{0x83, 0x00}},
+		{name: "unmasked client frame", raw: []byte{0x81, 0x00}, masked: true},
+		{name: "fragmented control frame", raw: []byte{0x09, 0x00}},
+		{name: "large control frame", raw: []byte{0x89, 126, 0x00, 126}},
+		{name: "truncated payload", raw: []byte{0x81, 0x05, 'a'}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readFrame(bytes.NewReader(tt.raw), tt.masked, DefaultReadLimit); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+// pipe returns a connected server and client, and the client's raw
+// connection for sending hand-crafted frames.
+func pipe(t *testing.T) (server, client *Conn, clientRaw net.Conn) {
+	t.Helper()
+	s, c := net.Pipe()
+	t.Cleanup(func() {
+		_ = s.Close()
+		_ = c.Close()
+	})
+	return newConn(s, bufio.NewReader(s), false), newConn(c, bufio.NewReader(c), true), c
+}
+
+func TestFragmentedMessage(t *testing.T) {
+	server, _, raw := pipe(t)
+
+	go func() {
+		// A ping in between fragments must be answered without breaking
+		// up the message.
+		for _, f := range []frame{
+			{fin: false, opcode: opText, payload: []byte("Hel")},
+			{fin: true, opcode: opPing, payload: []byte("ping")},
+			{fin: false, opcode: opContinuation, payload: []byte("lo, ")},
+			{fin: true, opcode: opContinuation, payload: []byte("World")},
+		} {
+			if err := writeFrame(raw, f, true); err != nil {
+				return
+			}
+		}
+	}()
+	pong := make(chan frame, 1)
+	go func() {
+		f, err := readFrame(raw, false, DefaultReadLimit)
+		if err == nil {
+			pong <- f
+		}
+	}()
+
+	typ, msg, err := server.ReadMessage()
+	if err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+	if typ != TextMessage || string(msg) != "Hello, World" {
+		t.Fatalf("message = %d %q, want text %q", typ, msg, "Hello, World")
+	}
+	if f := <-pong; f.opcode != opPong || string(f.payload) != "ping" {
+		t.Fatalf("reply = %#x %q, want pong %q", f.opcode, f.payload, "ping")
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	server, client, _ := pipe(t)
+
+	// The server echoes the close frame, which ends the client's read.
+	clientErr := make(chan error, 1)
+	go func() {
+		if err := client.WriteClose(StatusGoingAway, "bye"); err != nil {
+			clientErr <- err
+			return
+		}
+		_, _, err := client.ReadMessage()
+		clientErr <- err
+	}()
+
+	_, _, err := server.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("read message = %v, want *CloseError", err)
+	}
+	if closeErr.Code != StatusGoingAway || closeErr.Reason != "bye" {
+		t.Fatalf("close = %d %q, want %d %q", closeErr.Code, closeErr.Reason, StatusGoingAway, "bye")
+	}
+
+	if err := <-clientErr; !errors.As(err, &closeErr) || closeErr.Code != StatusGoingAway {
+		t.Fatalf("client read message = %v, want close %d", err, StatusGoingAway)
+	}
+	if err := server.WriteMessage(TextMessage, []byte("too late")); !errors.Is(err, ErrCloseSent) {
+		t.Fatalf("write after close = %v, want %v", err, ErrCloseSent)
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	server, _, raw := pipe(t)
+
+	go func() {
+		_ = writeFrame(raw, frame{fin: true, opcode: opText, payload: []byte{0xff, 0xfe}}, true)
+		// Read the close frame the server sends in response.
+		_, _ = readFrame(raw, false, DefaultReadLimit)
+	}()
+	_, _, err := server.ReadMessage()
+	if err == nil || !strings.Contains(err.Error(), "UTF-8") {
+		t.Fatalf("read message = %v, want UTF-8 error", err)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	server, client, _ := pipe(t)
+	server.SetReadLimit(4)
+
+	go func() {
+		_ = client.WriteMessage(BinaryMessage, []byte("too long"))
+		// Read the close frame the server sends in response.
+		_, _, _ = client.ReadMessage()
+	}()
+	if _, _, err := server.ReadMessage(); !errors.Is(err, errMessageTooBig) {
+		t.Fatalf("read message = %v, want %v", err, errMessageTooBig)
+	}
+}
@@ -0,0 +1,154 @@
+package sse
+
+import (
+	"slices"
+	"sync"
+)
+
+// defaultBuffer is how many events a subscriber may fall behind when
+// Broker.Buffer isn't set.
+const defaultBuffer = 16
+
+// SlowConsumerPolicy decides what happens to a subscriber that doesn't keep
+// up with the events.
+type SlowConsumerPolicy int
+
+const (
+	// DropEvents skips the events that don't fit in the buffer of the
+	// subscriber.
+	DropEvents SlowConsumerPolicy = iota
+	// Disconnect closes the subscription once its buffer is full. A
+	// browser reconnects and is sent the missed events from the Stream.
+	Disconnect
+)
+
+// Broker fans events out to subscribers, so a source is sampled once no
+// matter how many clients listen. The zero value is ready to use.
+type Broker struct {
+	// Stream, if set, numbers the events and records them for clients that
+	// reconnect.
+	Stream *Stream
+	// Buffer is how many events a subscriber may fall behind before Policy
+	// applies. Zero means a default of 16.
+	Buffer int
+	// Policy is applied to subscribers whose buffer is full.
+	Policy SlowConsumerPolicy
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives the events of a broker.
+type Subscription struct {
+	broker *Broker
+	// types are the event types to deliver, nil for all.
+	types []string
+	ch    chan Event
+	// dropped counts the events that didn't fit in ch, guarded by the
+	// broker's mutex.
+	dropped int
+	closed  bool
+}
+
+// Subscribe registers a subscriber for events of the given types, or all
+// events if no types are given. lastEventID is the Last-Event-ID header of
+// the request, the recorded events published after it are returned for the
+// caller to send before the ones from the subscription. Nothing published
+// in between is lost.
+func (b *Broker) Subscribe(lastEventID string, types ...string) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{broker: b, ch: make(chan Event, b.buffer())}
+	if len(types) > 0 {
+		sub.types = types
+	}
+	var missed []Event
+	if b.Stream != nil {
+		events, _ := b.Stream.Since(lastEventID)
+		for _, e := range events {
+			if sub.wants(e) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	if b.subs == nil {
+		b.subs = make(map[*Subscription]struct{})
+	}
+	b.subs[sub] = struct{}{}
+	return sub, missed
+}
+
+// Publish sends e to the subscribers that want it and returns it, with the
+// ID set by the stream. It never blocks on a subscriber.
+func (b *Broker) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.Stream != nil {
+		e = b.Stream.Publish(e)
+	}
+	for sub := range b.subs {
+		if !sub.wants(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped++
+			if b.Policy == Disconnect {
+				b.removeLocked(sub)
+			}
+		}
+	}
+	return e
+}
+
+// Subscribers returns the number of subscriptions.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func (b *Broker) buffer() int {
+	if b.Buffer > 0 {
+		return b.Buffer
+	}
+	return defaultBuffer
+}
+
+func (b *Broker) removeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(b.subs, sub)
+	close(sub.ch)
+}
+
+// Events returns the channel the events are delivered on. It is closed
+// when the subscription is, by Close or by the broker because the
+// subscriber was too slow.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped returns the number of events that were dropped because the
+// subscriber was too slow.
+func (s *Subscription) Dropped() int {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.dropped
+}
+
+// Close unsubscribes. It may be called more than once.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.removeLocked(s)
+}
+
+func (s *Subscription) wants(e Event) bool {
+	return s.types == nil || slices.Contains(s.types, e.Type)
+}
@@ -0,0 +1,99 @@
+package sse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBroker(t *testing.T) {
+	b := &Broker{Stream: NewStream(10)}
+	all, _ := b.Subscribe("")
+	mem, _ := b.Subscribe("", "mem")
+	if got := b.Subscribers(); got != 2 {
+		t.Fatalf("Subscribers = %d, want 2", got)
+	}
+
+	b.Publish(Event{Type: "mem", Data: "1"})
+	b.Publish(Event{Type: "cpu", Data: "2"})
+
+	for _, tt := range []struct {
+		sub  *Subscription
+		want []Event
+	}{
+		{sub: all, want: []Event{{ID: "1", Type: "mem", Data: "1"}, {ID: "2", Type: "cpu", Data: "2"}}},
+		{sub: mem, want: []Event{{ID: "1", Type: "mem", Data: "1"}}},
+	} {
+		var got []Event
+		for range tt.want {
+			got = append(got, <-tt.sub.Events())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("events = %+v, want %+v", got, tt.want)
+		}
+		select {
+		case e := <-tt.sub.Events():
+			t.Errorf("unexpected event %+v", e)
+		default:
+		}
+	}
+
+	mem.Close()
+	mem.Close()
+	if got := b.Subscribers(); got != 1 {
+		t.Fatalf("Subscribers after Close = %d, want 1", got)
+	}
+	if _, ok := <-mem.Events(); ok {
+		t.Fatal("Events of a closed subscription is still open")
+	}
+}
+
+func TestBrokerSubscribeReplays(t *testing.T) {
+	b := &Broker{Stream: NewStream(10)}
+	b.Publish(Event{Type: "mem", Data: "1"})
+	b.Publish(Event{Type: "cpu", Data: "2"})
+	b.Publish(Event{Type: "mem", Data: "3"})
+
+	_, missed := b.Subscribe("1", "mem")
+	if want := []Event{{ID: "3", Type: "mem", Data: "3"}}; !reflect.DeepEqual(missed, want) {
+		t.Fatalf("missed = %+v, want %+v", missed, want)
+	}
+}
+
+func TestBrokerSlowConsumer(t *testing.T) {
+	for _, tt := range []struct {
+		policy     SlowConsumerPolicy
+		wantClosed bool
+	}{
+		{policy: DropEvents, wantClosed: false},
+		{policy: Disconnect, wantClosed: true},
+	} {
+		b := &Broker{Buffer: 2, Policy: tt.policy}
+		slow, _ := b.Subscribe("")
+		fast, _ := b.Subscribe("")
+		for range 3 {
+			b.Publish(Event{Data: "x"})
+			<-fast.Events()
+		}
+
+		if got := slow.Dropped(); got != 1 {
+			t.Errorf("policy %d: Dropped = %d, want 1", tt.policy, got)
+		}
+		received := 0
+		for range slow.Events() {
+			received++
+			if received == 2 && !tt.wantClosed {
+				break
+			}
+		}
+		if received != 2 {
+			t.Errorf("policy %d: received %d events, want the 2 that fit", tt.policy, received)
+		}
+		if got, want := b.Subscribers(), 2; tt.wantClosed {
+			if got != want-1 {
+				t.Errorf("policy %d: Subscribers = %d, want the slow one removed", tt.policy, got)
+			}
+		} else if got != want {
+			t.Errorf("policy %d: Subscribers = %d, want %d", tt.policy, got, want)
+		}
+	}
+}
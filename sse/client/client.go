@@ -0,0 +1,207 @@
+// Package client reads Server-Sent Events, reconnecting like a browser's
+// EventSource does.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/sse"
+)
+
+const (
+	// defaultRetry is the reconnection delay until the server sets one.
+	defaultRetry = 3 * time.Second
+	// defaultMaxRetry caps the delay after failed attempts.
+	defaultMaxRetry = 30 * time.Second
+)
+
+// errNoContent ends the iteration, a server responds with 204 No Content
+// to tell clients to stop reconnecting.
+var errNoContent = errors.New("sse: no content")
+
+// permanentError is a failure that reconnecting won't fix, like a response
+// that isn't an event stream.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Client reads an event stream and reconnects when it ends.
+type Client struct {
+	// HTTPClient sends the requests. If nil, http.DefaultClient is used.
+	// Its Timeout must be zero, a stream lasts as long as the server
+	// sends it.
+	HTTPClient *http.Client
+	// LastEventID is sent in the Last-Event-ID header of the first
+	// request, to resume a stream that was read before.
+	LastEventID string
+	// Retry is the delay before reconnecting, until the server sets one
+	// with a retry field. If zero, 3 seconds.
+	Retry time.Duration
+	// MaxRetry caps the delay, which doubles with every failed attempt in
+	// a row. If zero, 30 seconds.
+	MaxRetry time.Duration
+}
+
+// Events returns an iterator over the events at url. When the stream ends
+// or breaks, the client waits for the retry delay and reconnects, sending
+// the ID of the last event in the Last-Event-ID header.
+//
+// Failed attempts are yielded as errors, the caller may stop or keep
+// iterating to retry. The iteration ends when ctx is done, when the server
+// responds with 204 No Content, or with an error if the response isn't an
+// event stream.
+func (c *Client) Events(ctx context.Context, url string) iter.Seq2[sse.Event, error] {
+	return func(yield func(sse.Event, error) bool) {
+		s := &stream{
+			client: c,
+			url:    url,
+			lastID: c.LastEventID,
+			retry:  c.retry(),
+			yield:  yield,
+		}
+		failures := 0
+		for {
+			connected, err := s.read(ctx)
+			if s.stopped || ctx.Err() != nil || errors.Is(err, errNoContent) {
+				return
+			}
+			var permanent *permanentError
+			if errors.As(err, &permanent) {
+				yield(sse.Event{}, permanent.err)
+				return
+			}
+			if err != nil && !yield(sse.Event{}, err) {
+				return
+			}
+
+			if connected {
+				failures = 0
+			}
+			delay := max(c.backoff(s.retry, failures), s.retryAfter)
+			failures++
+			s.retryAfter = 0
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt, after failures failed
+// ones in a row.
+func (c *Client) backoff(retry time.Duration, failures int) time.Duration {
+	maxRetry := c.MaxRetry
+	if maxRetry <= 0 {
+		maxRetry = defaultMaxRetry
+	}
+	delay := retry
+	for range failures {
+		if delay >= maxRetry {
+			break
+		}
+		delay *= 2
+	}
+	return min(delay, max(maxRetry, retry))
+}
+
+func (c *Client) retry() time.Duration {
+	if c.Retry > 0 {
+		return c.Retry
+	}
+	return defaultRetry
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// stream is the state of Client.Events that carries over from one
+// connection to the next.
+type stream struct {
+	client *Client
+	url    string
+	lastID string
+	retry  time.Duration
+	// retryAfter is the Retry-After delay of an unavailable server.
+	retryAfter time.Duration
+	yield      func(sse.Event, error) bool
+	// stopped is set once yield returned false.
+	stopped bool
+}
+
+// read makes one request and yields the events of the response until it
+// ends. connected reports whether the server responded with an event
+// stream.
+func (s *stream) read(ctx context.Context) (connected bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return false, &permanentError{err: err}
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if s.lastID != "" {
+		req.Header.Set("Last-Event-ID", s.lastID)
+	}
+
+	resp, err := s.client.httpClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return false, errNoContent
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		// The server or a proxy in front of it is busy or restarting.
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			s.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return false, fmt.Errorf("sse: %s", resp.Status)
+	default:
+		return false, &permanentError{err: fmt.Errorf("sse: unexpected status %s", resp.Status)}
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		return false, &permanentError{err: fmt.Errorf("sse: unexpected content type %q", resp.Header.Get("Content-Type"))}
+	}
+
+	dec := newDecoder(resp.Body, s.lastID)
+	for {
+		e, err := dec.next()
+		s.lastID = dec.lastID
+		if dec.retry > 0 {
+			s.retry = dec.retry
+		}
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		if !s.yield(e, nil) {
+			s.stopped = true
+			return true, nil
+		}
+	}
+}
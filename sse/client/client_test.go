@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientReconnects(t *testing.T) {
+	var requests atomic.Int32
+	var lastEventIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		if n == 3 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Each response ends the stream after one event.
+		_, _ = fmt.Fprintf(w, "retry: 1\nid: %d\ndata: event %d\n\n", n, n)
+	}))
+	defer server.Close()
+
+	c := &Client{LastEventID: "0"}
+	var got []string
+	for e, err := range c.Events(context.Background(), server.URL) {
+		if err != nil {
+			t.Fatalf("Events: %v", err)
+		}
+		got = append(got, e.ID+" "+e.Data)
+	}
+
+	if want := []string{"1 event 1", "2 event 2"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+	if want := []string{"0", "1", "2"}; fmt.Sprint(lastEventIDs) != fmt.Sprint(want) {
+		t.Fatalf("Last-Event-ID headers = %q, want %q", lastEventIDs, want)
+	}
+}
+
+func TestClientRetriesUnavailable(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: ok\n\n")
+	}))
+	defer server.Close()
+
+	c := &Client{Retry: time.Millisecond}
+	var errs int
+	for e, err := range c.Events(context.Background(), server.URL) {
+		if err != nil {
+			errs++
+			continue
+		}
+		if e.Data != "ok" {
+			t.Fatalf("data = %q, want ok", e.Data)
+		}
+		break
+	}
+	if errs != 1 {
+		t.Fatalf("got %d errors, want 1 for the 503", errs)
+	}
+}
+
+func TestClientPermanentErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{name: "not found", handler: http.NotFound},
+		{
+			name: "not an event stream",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = fmt.Fprint(w, "data: x\n\n")
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			var errs int
+			for _, err := range (&Client{Retry: time.Millisecond}).Events(context.Background(), server.URL) {
+				if err == nil {
+					t.Fatal("got an event, want an error")
+				}
+				errs++
+			}
+			if errs != 1 {
+				t.Fatalf("got %d errors, want 1 without reconnecting", errs)
+			}
+		})
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: first\n\n")
+		http.NewResponseController(w).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got int
+	for _, err := range (&Client{}).Events(ctx, server.URL) {
+		if err != nil {
+			t.Fatalf("Events: %v", err)
+		}
+		got++
+		cancel()
+	}
+	if got != 1 {
+		t.Fatalf("got %d events, want 1 before the context was canceled", got)
+	}
+}
+
+func TestClientBackoff(t *testing.T) {
+	c := &Client{MaxRetry: 10 * time.Second}
+	for _, tt := range []struct {
+		retry    time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{retry: time.Second, failures: 0, want: time.Second},
+		{retry: time.Second, failures: 2, want: 4 * time.Second},
+		{retry: time.Second, failures: 10, want: 10 * time.Second},
+		// A longer delay set by the server is honored.
+		{retry: time.Minute, failures: 3, want: time.Minute},
+	} {
+		if got := c.backoff(tt.retry, tt.failures); got != tt.want {
+			t.Errorf("backoff(%v, %d) = %v, want %v", tt.retry, tt.failures, got, tt.want)
+		}
+	}
+}
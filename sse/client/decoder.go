@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/sse"
+)
+
+// maxLineBytes limits the length of a line of the event stream.
+const maxLineBytes = 1 << 20
+
+// decoder parses the text/event-stream format as browsers do (HTML Living
+// Standard 9.2.6).
+type decoder struct {
+	scanner *bufio.Scanner
+	// lastID is the last event ID buffer. It outlives the connection, the
+	// next one sends it in Last-Event-ID.
+	lastID string
+	// retry is set by retry fields, zero until the server sends one.
+	retry time.Duration
+	// started is set once the first line, which may start with a byte
+	// order mark, was read.
+	started bool
+	// skipLF is set after a line ending in CR at the end of the data read
+	// so far, a LF after it is part of the same line break.
+	skipLF bool
+}
+
+func newDecoder(r io.Reader, lastID string) *decoder {
+	d := &decoder{lastID: lastID}
+	d.scanner = bufio.NewScanner(r)
+	d.scanner.Buffer(make([]byte, 0, 4096), maxLineBytes)
+	d.scanner.Split(d.scanLines)
+	return d
+}
+
+// next returns the next event. At the end of the stream it returns io.EOF,
+// an event that isn't terminated by a blank line is discarded.
+func (d *decoder) next() (sse.Event, error) {
+	var data strings.Builder
+	var typ string
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+		if !d.started {
+			line = strings.TrimPrefix(line, "\ufeff")
+			d.started = true
+		}
+
+		if line == "" {
+			if data.Len() == 0 {
+				// Events without data aren't dispatched.
+				typ = ""
+				continue
+			}
+			if typ == "" {
+				typ = "message"
+			}
+			return sse.Event{
+				ID:   d.lastID,
+				Type: typ,
+				Data: strings.TrimSuffix(data.String(), "\n"),
+			}, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			// A comment, e.g. a heartbeat.
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "event":
+			typ = value
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				d.lastID = value
+			}
+		case "retry":
+			ms, err := strconv.ParseUint(value, 10, 64)
+			if err == nil && ms <= uint64(math.MaxInt64/time.Millisecond) {
+				d.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := d.scanner.Err(); err != nil {
+		return sse.Event{}, err
+	}
+	return sse.Event{}, io.EOF
+}
+
+// scanLines is a bufio.SplitFunc for lines ending in CRLF, LF or CR. A line
+// ending in CR is returned right away, rather than when the next write shows
+// whether a LF follows, like browsers do.
+func (d *decoder) scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if d.skipLF && len(data) > 0 {
+		d.skipLF = false
+		if data[0] == '\n' {
+			// Skipped in the same call, the scanner would wait for more
+			// data first.
+			advance, token, err = d.scanLines(data[1:], atEOF)
+			return advance + 1, token, err
+		}
+	}
+	i := bytes.IndexAny(data, "\r\n")
+	switch {
+	case i < 0:
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	case data[i] == '\n':
+		return i + 1, data[:i], nil
+	case i+1 < len(data):
+		if data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+		return i + 1, data[:i], nil
+	}
+	d.skipLF = true
+	return i + 1, data[:i], nil
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/sse"
+)
+
+func TestDecoder(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		raw       string
+		want      []sse.Event
+		wantRetry time.Duration
+	}{
+		{
+			name: "message",
+			raw:  "data: hello\n\n",
+			want: []sse.Event{{Type: "message", Data: "hello"}},
+		},
+		{
+			name: "all fields",
+			raw:  "id: 7\nevent: mem\nretry: 1500\ndata: x\n\n",
+			want: []sse.Event{{ID: "7", Type: "mem", Data: "x"}},
+			// Retry isn't part of the event.
+			wantRetry: 1500 * time.Millisecond,
+		},
+		{
+			name: "multi-line data",
+			raw:  "data: a\ndata:b\ndata:  c\ndata\n\n",
+			want: []sse.Event{{Type: "message", Data: "a\nb\n c\n"}},
+		},
+		{
+			name: "line endings",
+			raw:  "data: a\r\ndata: b\rdata: c\n\r\n",
+			want: []sse.Event{{Type: "message", Data: "a\nb\nc"}},
+		},
+		{
+			name: "comments and unknown fields",
+			raw:  ": heartbeat\nfoo: bar\ndata: x\n\n:\n\n",
+			want: []sse.Event{{Type: "message", Data: "x"}},
+		},
+		{
+			name: "byte order mark",
+			raw:  "\ufeffdata: x\n\ndata: \ufeffy\n\n",
+			want: []sse.Event{{Type: "message", Data: "x"}, {Type: "message", Data: "\ufeffy"}},
+		},
+		{
+			name: "ID carries over",
+			raw:  "id: 1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			want: []sse.Event{{ID: "1", Type: "message", Data: "a"}, {ID: "1", Type: "message", Data: "b"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name: "ID with NUL is ignored",
+			raw:  "id: 1\ndata: a\n\nid: 2\x00\ndata: b\n\n",
+			want: []sse.Event{{ID: "1", Type: "message", Data: "a"}, {ID: "1", Type: "message", Data: "b"}},
+		},
+		{
+			name:      "invalid retry is ignored",
+			raw:       "retry: 10\n\nretry: 1s\n\nretry: -5\n\n",
+			wantRetry: 10 * time.Millisecond,
+		},
+		{
+			name: "no data",
+			raw:  "event: mem\n\ndata: x\n\n",
+			want: []sse.Event{{Type: "message", Data: "x"}},
+		},
+		{
+			name: "unterminated event",
+			raw:  "data: a\n\ndata: b\n",
+			want: []sse.Event{{Type: "message", Data: "a"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// One byte at a time, so CRLF is split across reads.
+			d := newDecoder(iotest.OneByteReader(strings.NewReader(tt.raw)), "")
+			var got []sse.Event
+			for {
+				e, err := d.next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("next: %v", err)
+				}
+				got = append(got, e)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("events = %+v, want %+v", got, tt.want)
+			}
+			if d.retry != tt.wantRetry {
+				t.Fatalf("retry = %v, want %v", d.retry, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestDecoderCRAtEndOfWrite(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer func() { _ = pw.Close() }()
+	d := newDecoder(pr, "")
+	next := func(write string, want sse.Event) {
+		t.Helper()
+		go func() { _, _ = io.WriteString(pw, write) }()
+		got, err := d.next()
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		if got != want {
+			t.Fatalf("event = %+v, want %+v", got, want)
+		}
+	}
+
+	// The event is dispatched without waiting for the next write.
+	next("data: a\r\r", sse.Event{Type: "message", Data: "a"})
+	// A LF starting the next write ends the same line.
+	next("\ndata: b\r\r", sse.Event{Type: "message", Data: "b"})
+}
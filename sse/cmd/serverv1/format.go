@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// formatText formats the fields of a sample one per line, e.g.
+// "Total: 123".
+func formatText(sample any) (string, error) {
+	v := reflect.ValueOf(sample)
+	if v.Kind() != reflect.Struct {
+		return fmt.Sprint(sample), nil
+	}
+	lines := make([]string, v.NumField())
+	for i := range v.NumField() {
+		lines[i] = fmt.Sprintf("%s: %v", v.Type().Field(i).Name, v.Field(i))
+	}
+	return strings.Join(lines, "\n"), nil
+}
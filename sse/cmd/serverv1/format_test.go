@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fredrikaverpil/go-playground/sse/monitor"
+)
+
+func TestFormatText(t *testing.T) {
+	for _, tt := range []struct {
+		sample any
+		want   string
+	}{
+		{sample: monitor.LoadInfo{Load1: 1.5, Load5: 1, Load15: 0.25}, want: "Load1: 1.5\nLoad5: 1\nLoad15: 0.25"},
+		{sample: monitor.FakeSample{N: 3}, want: "N: 3"},
+		{sample: 42, want: "42"},
+	} {
+		got, err := formatText(tt.sample)
+		if err != nil {
+			t.Fatalf("formatText(%+v): %v", tt.sample, err)
+		}
+		if got != tt.want {
+			t.Fatalf("formatText(%+v) = %q, want %q", tt.sample, got, tt.want)
+		}
+	}
+}
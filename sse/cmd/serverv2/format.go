@@ -0,0 +1,9 @@
+package main
+
+import "encoding/json"
+
+// formatJSON encodes a sample as JSON.
+func formatJSON(sample any) (string, error) {
+	data, err := json.Marshal(sample)
+	return string(data), err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/sse"
+	"github.com/fredrikaverpil/go-playground/sse/monitor"
+)
+
+func TestFormatJSON(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		sources := []monitor.Source{
+			monitor.NewFake("mem", 3*time.Second),
+			monitor.NewFake("cpu", 2*time.Second),
+		}
+		broker := &sse.Broker{Stream: sse.NewStream(10)}
+		sampled := make(chan struct{})
+		go func() {
+			monitor.Run(ctx, broker, formatJSON, sources...)
+			close(sampled)
+		}()
+
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/events?types=cpu", nil)
+		rec := httptest.NewRecorder()
+		served := make(chan struct{})
+		go func() {
+			monitor.Handler(broker, sources, 0).ServeHTTP(rec, req)
+			close(served)
+		}()
+
+		time.Sleep(5 * time.Second)
+		synctest.Wait()
+		cancel()
+		<-served
+		<-sampled
+
+		// The mem event in between is published too, it takes ID 2.
+		want := "retry: 3000\n\n" +
+			"id: 1\nevent: cpu\ndata: {\"n\":1}\n\n" +
+			"id: 3\nevent: cpu\ndata: {\"n\":2}\n\n"
+		if got := rec.Body.String(); got != want {
+			t.Fatalf("body = %q, want %q", got, want)
+		}
+	})
+}
@@ -1,19 +1,21 @@
 package main
 
 import (
+	"flag"
 	"log"
-	"net/http"
 	"time"
+
+	"github.com/fredrikaverpil/go-playground/sse/monitor"
 )
 
 func main() {
-	http.HandleFunc("/events", sseHandler)
-
-	server := &http.Server{
-		Addr:              ":8080",
-		ReadHeaderTimeout: 10 * time.Second,
-	}
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("error starting server: %s\n", err)
+	interval := flag.Duration("interval", time.Second, "how often metrics are sampled")
+	maxStreams := flag.Int("max-streams", 100, "maximum number of concurrent event streams")
+	flag.Parse()
+	if *interval <= 0 {
+		log.Fatalf("error: -interval must be positive, got %s\n", *interval)
 	}
+
+	err := monitor.ListenAndServe(":8080", formatJSON, monitor.DefaultSources(*interval), *maxStreams)
+	log.Fatalf("error starting server: %s\n", err)
 }
@@ -0,0 +1,72 @@
+// Package sse implements the server side of Server-Sent Events, the
+// text/event-stream format browsers read with EventSource.
+//
+// A Writer sends events on a response. A Stream numbers the events of a
+// source and keeps the last ones, so a client that reconnects with the
+// Last-Event-ID header gets the events it missed. A Broker fans the events
+// of one source out to all clients.
+package sse
+
+import (
+	"errors"
+	"io"
+	"iter"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is one message of an event stream.
+type Event struct {
+	// ID is sent back by the client in the Last-Event-ID header when it
+	// reconnects. Stream.Publish sets it.
+	ID string
+	// Type is the name EventSource listeners are added for, clients treat
+	// an empty type as "message".
+	Type string
+	// Data is the payload. It may span several lines, each is sent in its
+	// own data field. Events without data only update the ID and retry
+	// delay of the client.
+	Data string
+	// Retry, if set, is how long the client waits before reconnecting.
+	Retry time.Duration
+}
+
+// WriteTo writes the event in the text/event-stream format, terminated by
+// the blank line that makes the client dispatch it.
+func (e Event) WriteTo(w io.Writer) (int64, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return 0, errors.New("sse: invalid event ID")
+	}
+	if strings.ContainsAny(e.Type, "\r\n") {
+		return 0, errors.New("sse: invalid event type")
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Type != "" {
+		b.WriteString("event: " + e.Type + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	if e.Data != "" {
+		for line := range lines(e.Data) {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// lines splits s into lines. Clients end fields on CRLF, CR and LF alike, a
+// bare CR in a value would end the field early.
+func lines(s string) iter.Seq[string] {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.SplitSeq(s, "\n")
+}
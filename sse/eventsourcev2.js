@@ -1,4 +1,4 @@
-const eventSource = new EventSource("http://127.0.0.1:8080/events");
+const eventSource = new EventSource("http://127.0.0.1:8080/events?types=mem,cpu");
 const mem = document.getElementById("mem");
 const cpu = document.getElementById("cpu");
 
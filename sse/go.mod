@@ -1,6 +1,6 @@
 module github.com/fredrikaverpil/go-playground/sse
 
-go 1.24.1
+go 1.25.0
 
 require github.com/shirou/gopsutil/v4 v4.25.2
 
@@ -0,0 +1,140 @@
+package sse
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Handler streams the events of a Broker to the clients that request them.
+// A client that reconnects first gets the events it missed.
+type Handler struct {
+	Broker *Broker
+	// Types are the event types clients may subscribe to with the types
+	// query parameter, e.g. ?types=mem,cpu or ?types=mem&types=cpu.
+	// Others are rejected with 400 Bad Request. Without the parameter a
+	// client gets all events.
+	Types []string
+	// Retry, if set, is sent to clients as the delay before reconnecting.
+	Retry time.Duration
+	// Heartbeat, if set, is how long a stream may be idle before a comment
+	// is sent, so proxies don't take it for a dead connection.
+	Heartbeat time.Duration
+	// MaxLifetime, if set, ends streams after that long. Clients reconnect
+	// and resume from the last event, which spreads them over the servers
+	// behind a load balancer.
+	MaxLifetime time.Duration
+	// MaxStreams, if set, limits the concurrent streams. Clients over the
+	// limit get 503 Service Unavailable with a Retry-After header.
+	MaxStreams int
+	// WriteTimeout, if set, is how long writing each event may take, see
+	// Writer.
+	WriteTimeout time.Duration
+
+	streams atomic.Int64
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	types, err := h.requestedTypes(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n := h.streams.Add(1); h.MaxStreams > 0 && n > int64(h.MaxStreams) {
+		h.streams.Add(-1)
+		w.Header().Set("Retry-After", strconv.Itoa(h.retryAfter()))
+		http.Error(w, "too many streams", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.streams.Add(-1)
+
+	sw := NewWriter(w)
+	sw.WriteTimeout = h.WriteTimeout
+
+	sub, missed := h.Broker.Subscribe(r.Header.Get("Last-Event-ID"), types...)
+	defer sub.Close()
+
+	if h.Retry > 0 {
+		if err := sw.Send(Event{Retry: h.Retry}); err != nil {
+			return
+		}
+	}
+	for _, e := range missed {
+		if err := sw.Send(e); err != nil {
+			return
+		}
+	}
+
+	// A nil channel never fires, for the features that are off.
+	var heartbeat, expired <-chan time.Time
+	var heartbeatTimer *time.Timer
+	if h.Heartbeat > 0 {
+		heartbeatTimer = time.NewTimer(h.Heartbeat)
+		defer heartbeatTimer.Stop()
+		heartbeat = heartbeatTimer.C
+	}
+	if h.MaxLifetime > 0 {
+		lifetime := time.NewTimer(h.MaxLifetime)
+		defer lifetime.Stop()
+		expired = lifetime.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-expired:
+			return
+
+		case e, ok := <-sub.Events():
+			if !ok {
+				// The broker disconnected us for being too slow.
+				return
+			}
+			if err := sw.Send(e); err != nil {
+				return
+			}
+			if heartbeatTimer != nil {
+				heartbeatTimer.Reset(h.Heartbeat)
+			}
+
+		case <-heartbeat:
+			if err := sw.Comment("heartbeat"); err != nil {
+				return
+			}
+			heartbeatTimer.Reset(h.Heartbeat)
+		}
+	}
+}
+
+// Streams returns the number of streams being served.
+func (h *Handler) Streams() int {
+	return int(h.streams.Load())
+}
+
+// requestedTypes returns the event types listed in the types query
+// parameter, or nil for all.
+func (h *Handler) requestedTypes(r *http.Request) ([]string, error) {
+	var types []string
+	for _, value := range r.URL.Query()["types"] {
+		for typ := range strings.SplitSeq(value, ",") {
+			if !slices.Contains(h.Types, typ) {
+				return nil, fmt.Errorf("unknown event type %q", typ)
+			}
+			types = append(types, typ)
+		}
+	}
+	return types, nil
+}
+
+// retryAfter returns the Retry-After seconds for clients over the limit,
+// the retry delay rounded up.
+func (h *Handler) retryAfter() int {
+	return max(1, int(math.Ceil(h.Retry.Seconds())))
+}
@@ -0,0 +1,115 @@
+package sse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// serve runs h for a request to target in the background. cancel ends the
+// request, done is closed once the handler returned.
+func serve(ctx context.Context, h http.Handler, target string) (rec *httptest.ResponseRecorder, cancel func(), done <-chan struct{}) {
+	ctx, cancel = context.WithCancel(ctx)
+	rec = httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		h.ServeHTTP(rec, httptest.NewRequestWithContext(ctx, http.MethodGet, target, nil))
+		close(served)
+	}()
+	return rec, cancel, served
+}
+
+func TestHandlerHeartbeat(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := &Broker{}
+		h := &Handler{Broker: b, Heartbeat: 10 * time.Second}
+		rec, cancel, done := serve(t.Context(), h, "/")
+
+		// Heartbeats at 10 and 20 seconds, the event at 25 seconds pushes
+		// the next one to 35 seconds.
+		time.Sleep(25 * time.Second)
+		synctest.Wait()
+		b.Publish(Event{Data: "x"})
+		time.Sleep(9 * time.Second)
+		synctest.Wait()
+		cancel()
+		<-done
+
+		if got, want := rec.Body.String(), ": heartbeat\n: heartbeat\ndata: x\n\n"; got != want {
+			t.Fatalf("body = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestHandlerMaxLifetime(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		h := &Handler{Broker: &Broker{}, MaxLifetime: time.Minute}
+		_, cancel, done := serve(t.Context(), h, "/")
+		defer cancel()
+
+		time.Sleep(time.Minute - time.Second)
+		synctest.Wait()
+		select {
+		case <-done:
+			t.Fatal("stream ended before its lifetime")
+		default:
+		}
+		time.Sleep(time.Second)
+		synctest.Wait()
+		select {
+		case <-done:
+		default:
+			t.Fatal("stream outlived its lifetime")
+		}
+	})
+}
+
+func TestHandlerMaxStreams(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		h := &Handler{Broker: &Broker{}, MaxStreams: 1, Retry: 2500 * time.Millisecond}
+		_, cancel, done := serve(t.Context(), h, "/")
+		synctest.Wait()
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "3" {
+			t.Fatalf("response = %d with Retry-After %q, want 503 with 3", rec.Code, rec.Header().Get("Retry-After"))
+		}
+		if got := h.Streams(); got != 1 {
+			t.Fatalf("Streams = %d, want 1", got)
+		}
+
+		cancel()
+		<-done
+		if got := h.Streams(); got != 0 {
+			t.Fatalf("Streams after the stream ended = %d, want 0", got)
+		}
+	})
+}
+
+func TestHandlerTypes(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := &Broker{}
+		h := &Handler{Broker: b, Types: []string{"mem", "cpu"}, Retry: time.Second}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?types=mem,disk", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400 for an unknown type", rec.Code)
+		}
+
+		rec, cancel, done := serve(t.Context(), h, "/?types=cpu")
+		synctest.Wait()
+		b.Publish(Event{Type: "mem", Data: "1"})
+		b.Publish(Event{Type: "cpu", Data: "2"})
+		synctest.Wait()
+		cancel()
+		<-done
+		if got, want := rec.Body.String(), "retry: 1000\n\nevent: cpu\ndata: 2\n\n"; got != want {
+			t.Fatalf("body = %q, want %q", got, want)
+		}
+	})
+}
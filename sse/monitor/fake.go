@@ -0,0 +1,49 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FakeSample is the sample of a Fake source.
+type FakeSample struct {
+	N int `json:"n"`
+}
+
+// Fake is a Source for tests. Its samples count up from 1, so the events a
+// handler sends are predictable.
+type Fake struct {
+	name     string
+	interval time.Duration
+
+	mu  sync.Mutex
+	n   int
+	err error
+}
+
+// NewFake returns a fake source with the given name and interval.
+func NewFake(name string, interval time.Duration) *Fake {
+	return &Fake{name: name, interval: interval}
+}
+
+func (f *Fake) Name() string            { return f.name }
+func (f *Fake) Interval() time.Duration { return f.interval }
+
+// Sample returns the next sample, or the error set with SetErr.
+func (f *Fake) Sample(context.Context) (any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.n++
+	return FakeSample{N: f.n}, nil
+}
+
+// SetErr makes the samples fail with err, until it is set to nil again.
+func (f *Fake) SetErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
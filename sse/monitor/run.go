@@ -0,0 +1,65 @@
+package monitor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/sse"
+)
+
+// Run samples each source at its interval and publishes the samples to
+// broker, as events named after the source with data encoded by encode,
+// until ctx is done. Nothing is sampled while the broker has no
+// subscribers. Failed samples are logged and skipped, as are sources whose
+// interval isn't positive.
+func Run(ctx context.Context, broker *sse.Broker, encode func(sample any) (string, error), sources ...Source) {
+	var wg sync.WaitGroup
+	for _, s := range sources {
+		wg.Go(func() { run(ctx, broker, encode, s) })
+	}
+	wg.Wait()
+}
+
+func run(ctx context.Context, broker *sse.Broker, encode func(sample any) (string, error), s Source) {
+	if s.Interval() <= 0 {
+		log.Printf("error sampling %s: interval %s isn't positive\n", s.Name(), s.Interval())
+		return
+	}
+	t := time.NewTicker(s.Interval())
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+			if broker.Subscribers() == 0 {
+				continue
+			}
+			sample, err := s.Sample(ctx)
+			if err != nil {
+				log.Printf("error sampling %s: %s\n", s.Name(), err)
+				continue
+			}
+			data, err := encode(sample)
+			if err != nil {
+				log.Printf("error encoding %s sample: %s\n", s.Name(), err)
+				continue
+			}
+			broker.Publish(sse.Event{Type: s.Name(), Data: data})
+		}
+	}
+}
+
+// Names returns the names of sources, the event types they are published
+// as.
+func Names(sources []Source) []string {
+	names := make([]string, len(sources))
+	for i, s := range sources {
+		names[i] = s.Name()
+	}
+	return names
+}
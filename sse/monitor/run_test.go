@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/sse"
+)
+
+func encodeJSON(sample any) (string, error) {
+	data, err := json.Marshal(sample)
+	return string(data), err
+}
+
+// receive returns the events that were delivered to sub so far.
+func receive(sub *sse.Subscription) []sse.Event {
+	var events []sse.Event
+	for {
+		select {
+		case e := <-sub.Events():
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		broker := &sse.Broker{Buffer: 100}
+		fast, slow := NewFake("fast", time.Second), NewFake("slow", 3*time.Second)
+		done := make(chan struct{})
+		go func() {
+			Run(ctx, broker, encodeJSON, fast, slow)
+			close(done)
+		}()
+
+		// Nothing is sampled without subscribers.
+		time.Sleep(5*time.Second + time.Second/2)
+		sub, _ := broker.Subscribe("")
+		defer sub.Close()
+
+		time.Sleep(6 * time.Second)
+		synctest.Wait()
+		counts := map[string][]string{}
+		for _, e := range receive(sub) {
+			counts[e.Type] = append(counts[e.Type], e.Data)
+		}
+		if got := counts["fast"]; len(got) != 6 || got[0] != `{"n":1}` || got[5] != `{"n":6}` {
+			t.Fatalf("fast events = %q, want samples 1 to 6", got)
+		}
+		if got := counts["slow"]; len(got) != 2 || got[0] != `{"n":1}` {
+			t.Fatalf("slow events = %q, want samples 1 and 2", got)
+		}
+
+		// Failed samples are skipped.
+		fast.SetErr(errors.New("broken"))
+		time.Sleep(time.Second)
+		synctest.Wait()
+		for _, e := range receive(sub) {
+			if e.Type == "fast" {
+				t.Fatalf("got %+v from a failing source", e)
+			}
+		}
+
+		cancel()
+		<-done
+	})
+}
+
+func TestRunInvalidInterval(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		broker := &sse.Broker{}
+		sub, _ := broker.Subscribe("")
+		defer sub.Close()
+		done := make(chan struct{})
+		go func() {
+			Run(ctx, broker, encodeJSON, NewFake("zero", 0), NewFake("fast", time.Second))
+			close(done)
+		}()
+
+		// The source without an interval is skipped, the others still run.
+		time.Sleep(time.Second)
+		synctest.Wait()
+		if got := receive(sub); len(got) != 1 || got[0].Type != "fast" {
+			t.Fatalf("events = %+v, want one fast event", got)
+		}
+		cancel()
+		<-done
+	})
+}
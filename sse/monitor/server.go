@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/sse"
+)
+
+// Settings of the event streams.
+const (
+	// replayEvents is how many events are kept for clients that reconnect.
+	replayEvents = 100
+	// retryDelay is how long browsers wait before reconnecting.
+	retryDelay = 3 * time.Second
+	// heartbeatInterval is how long a stream may be idle. Proxies commonly
+	// drop connections idle for a minute.
+	heartbeatInterval = 15 * time.Second
+	// maxStreamLifetime makes clients reconnect now and then, possibly to
+	// another server.
+	maxStreamLifetime = 30 * time.Minute
+	// writeTimeout cuts off clients that stop reading.
+	writeTimeout = 10 * time.Second
+)
+
+// DefaultSources returns the sources of this machine and process, sampled
+// every interval.
+func DefaultSources(interval time.Duration) []Source {
+	return []Source{
+		Memory(interval),
+		CPU(interval),
+		Disk("/", interval),
+		Network(interval),
+		LoadAverage(interval),
+		Process(int32(os.Getpid()), interval), //nolint:gosec // pids fit in int32
+	}
+}
+
+// NewBroker returns a broker that keeps the last events. Slow clients are
+// disconnected, browsers reconnect and get the missed events replayed.
+func NewBroker() *sse.Broker {
+	return &sse.Broker{Stream: sse.NewStream(replayEvents), Policy: sse.Disconnect}
+}
+
+// Handler streams the events of broker, which are published by sources, to
+// at most maxStreams clients at a time. Pages from any origin may connect.
+func Handler(broker *sse.Broker, sources []Source, maxStreams int) http.Handler {
+	h := &sse.Handler{
+		Broker:       broker,
+		Types:        Names(sources),
+		Retry:        retryDelay,
+		Heartbeat:    heartbeatInterval,
+		MaxLifetime:  maxStreamLifetime,
+		MaxStreams:   maxStreams,
+		WriteTimeout: writeTimeout,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ListenAndServe samples sources, encoding the samples with encode, and
+// streams them to clients of /events on addr.
+func ListenAndServe(addr string, encode func(sample any) (string, error), sources []Source, maxStreams int) error {
+	broker := NewBroker()
+	go Run(context.Background(), broker, encode, sources...)
+
+	mux := http.NewServeMux()
+	mux.Handle("/events", Handler(broker, sources, maxStreams))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Streams extend the deadline before every write.
+		WriteTimeout: writeTimeout,
+	}
+	return server.ListenAndServe()
+}
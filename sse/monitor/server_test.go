@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/go-playground/sse"
+	"github.com/fredrikaverpil/go-playground/sse/client"
+)
+
+// testSources are the event types the tests publish themselves.
+var testSources = []Source{NewFake("mem", time.Second), NewFake("cpu", time.Second)}
+
+// waitForSubscribers waits until broker has n subscribers, and reports
+// whether it got them within a few seconds.
+func waitForSubscribers(broker *sse.Broker, n int) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Subscribers() != n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func TestHandler(t *testing.T) {
+	broker := &sse.Broker{Stream: sse.NewStream(10)}
+	server := httptest.NewServer(Handler(broker, testSources, 0))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := &client.Client{}
+	events := c.Events(ctx, server.URL)
+
+	// Publish once the handler subscribed, then read the events.
+	go func() {
+		if !waitForSubscribers(broker, 1) {
+			t.Error("the handler didn't subscribe")
+			return
+		}
+		broker.Publish(sse.Event{Type: "mem", Data: "Total: 1\nFree: 2"})
+		broker.Publish(sse.Event{Type: "cpu", Data: "User: 3"})
+	}()
+	var got []sse.Event
+	for e, err := range events {
+		if err != nil {
+			t.Fatalf("Events: %v", err)
+		}
+		got = append(got, e)
+		if len(got) == 2 {
+			break
+		}
+	}
+	want := []sse.Event{
+		{ID: "1", Type: "mem", Data: "Total: 1\nFree: 2"},
+		{ID: "2", Type: "cpu", Data: "User: 3"},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("events = %+v, want %+v", got, want)
+	}
+
+	// Events published while the client is away are replayed when it
+	// resumes from the last one it saw.
+	if !waitForSubscribers(broker, 0) {
+		t.Fatal("the handler didn't unsubscribe after the client left")
+	}
+	broker.Publish(sse.Event{Type: "mem", Data: "Total: 4"})
+	broker.Publish(sse.Event{Type: "cpu", Data: "User: 5"})
+	resumed := &client.Client{LastEventID: got[1].ID}
+	for e, err := range resumed.Events(ctx, server.URL+"?types=cpu") {
+		if err != nil {
+			t.Fatalf("Events: %v", err)
+		}
+		if want := (sse.Event{ID: "4", Type: "cpu", Data: "User: 5"}); e != want {
+			t.Fatalf("replayed event = %+v, want %+v", e, want)
+		}
+		break
+	}
+}
+
+func TestHandlerUnknownType(t *testing.T) {
+	server := httptest.NewServer(Handler(&sse.Broker{}, testSources, 0))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?types=mem,disk")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,214 @@
+// Package monitor samples system metrics and publishes them as Server-Sent
+// Events. Each metric is a Source, sampled at its own interval.
+package monitor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/disk"
+	"github.com/shirou/gopsutil/v4/load"
+	"github.com/shirou/gopsutil/v4/mem"
+	"github.com/shirou/gopsutil/v4/net"
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// Source is a metric that is sampled at a fixed interval.
+type Source interface {
+	// Name is the event type the samples are published as.
+	Name() string
+	// Interval is how often the source is sampled.
+	Interval() time.Duration
+	// Sample returns the current value of the metric, a struct that
+	// encodes to JSON.
+	Sample(ctx context.Context) (any, error)
+}
+
+// source is a Source backed by a function.
+type source struct {
+	name     string
+	interval time.Duration
+	sample   func(ctx context.Context) (any, error)
+}
+
+func (s *source) Name() string                            { return s.name }
+func (s *source) Interval() time.Duration                 { return s.interval }
+func (s *source) Sample(ctx context.Context) (any, error) { return s.sample(ctx) }
+
+// MemoryInfo is the sample of Memory.
+type MemoryInfo struct {
+	Total       uint64  `json:"total"`
+	Free        uint64  `json:"free"`
+	Available   uint64  `json:"available"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"usedPercent"`
+}
+
+// Memory samples the virtual memory usage as "mem" events.
+func Memory(interval time.Duration) Source {
+	return &source{name: "mem", interval: interval, sample: func(ctx context.Context) (any, error) {
+		m, err := mem.VirtualMemoryWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return MemoryInfo{
+			Total:       m.Total,
+			Free:        m.Free,
+			Available:   m.Available,
+			Used:        m.Used,
+			UsedPercent: m.UsedPercent,
+		}, nil
+	}}
+}
+
+// CPUInfo is the sample of CPU.
+type CPUInfo struct {
+	User   float64 `json:"user"`
+	System float64 `json:"system"`
+	Idle   float64 `json:"idle"`
+}
+
+// CPU samples the time all CPUs spent in user and system mode and idle, in
+// seconds, as "cpu" events.
+func CPU(interval time.Duration) Source {
+	return &source{name: "cpu", interval: interval, sample: func(ctx context.Context) (any, error) {
+		c, err := cpu.TimesWithContext(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(c) == 0 {
+			return nil, errors.New("no CPU times")
+		}
+		return CPUInfo{
+			User:   c[0].User,
+			System: c[0].System,
+			Idle:   c[0].Idle,
+		}, nil
+	}}
+}
+
+// DiskInfo is the sample of Disk.
+type DiskInfo struct {
+	Path        string  `json:"path"`
+	Total       uint64  `json:"total"`
+	Free        uint64  `json:"free"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"usedPercent"`
+}
+
+// Disk samples the usage of the file system path is on as "disk" events.
+func Disk(path string, interval time.Duration) Source {
+	return &source{name: "disk", interval: interval, sample: func(ctx context.Context) (any, error) {
+		d, err := disk.UsageWithContext(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return DiskInfo{
+			Path:        d.Path,
+			Total:       d.Total,
+			Free:        d.Free,
+			Used:        d.Used,
+			UsedPercent: d.UsedPercent,
+		}, nil
+	}}
+}
+
+// NetworkInfo is the sample of Network.
+type NetworkInfo struct {
+	BytesSent   uint64 `json:"bytesSent"`
+	BytesRecv   uint64 `json:"bytesRecv"`
+	PacketsSent uint64 `json:"packetsSent"`
+	PacketsRecv uint64 `json:"packetsRecv"`
+}
+
+// Network samples the traffic of all network interfaces since boot as
+// "net" events.
+func Network(interval time.Duration) Source {
+	return &source{name: "net", interval: interval, sample: func(ctx context.Context) (any, error) {
+		n, err := net.IOCountersWithContext(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(n) == 0 {
+			return nil, errors.New("no network counters")
+		}
+		return NetworkInfo{
+			BytesSent:   n[0].BytesSent,
+			BytesRecv:   n[0].BytesRecv,
+			PacketsSent: n[0].PacketsSent,
+			PacketsRecv: n[0].PacketsRecv,
+		}, nil
+	}}
+}
+
+// LoadInfo is the sample of LoadAverage.
+type LoadInfo struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+// LoadAverage samples the 1, 5 and 15 minute load averages as "load"
+// events.
+func LoadAverage(interval time.Duration) Source {
+	return &source{name: "load", interval: interval, sample: func(ctx context.Context) (any, error) {
+		l, err := load.AvgWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return LoadInfo{Load1: l.Load1, Load5: l.Load5, Load15: l.Load15}, nil
+	}}
+}
+
+// ProcessInfo is the sample of Process.
+type ProcessInfo struct {
+	PID  int32  `json:"pid"`
+	Name string `json:"name"`
+	// CPUPercent is the CPU usage since the previous sample, 100 for each
+	// fully used core. The first sample reports 0.
+	CPUPercent float64 `json:"cpuPercent"`
+	RSS        uint64  `json:"rss"`
+	NumThreads int32   `json:"numThreads"`
+}
+
+// Process samples the CPU and memory usage of the process with the given
+// pid as "process" events.
+func Process(pid int32, interval time.Duration) Source {
+	// The process remembers its CPU times between samples, so the usage is
+	// measured over the last interval rather than since it started.
+	p, newErr := process.NewProcess(pid)
+	var mu sync.Mutex
+	return &source{name: "process", interval: interval, sample: func(ctx context.Context) (any, error) {
+		if newErr != nil {
+			return nil, newErr
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		name, err := p.NameWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cpuPercent, err := p.PercentWithContext(ctx, 0)
+		if err != nil {
+			return nil, err
+		}
+		m, err := p.MemoryInfoWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		threads, err := p.NumThreadsWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ProcessInfo{
+			PID:        pid,
+			Name:       name,
+			CPUPercent: cpuPercent,
+			RSS:        m.RSS,
+			NumThreads: threads,
+		}, nil
+	}}
+}
@@ -0,0 +1,51 @@
+package monitor
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSources(t *testing.T) {
+	for _, s := range []Source{
+		Memory(time.Second),
+		CPU(time.Second),
+		Disk(os.TempDir(), time.Second),
+		Network(time.Second),
+		LoadAverage(time.Second),
+		Process(int32(os.Getpid()), time.Second), //nolint:gosec // pids fit in int32
+	} {
+		t.Run(s.Name(), func(t *testing.T) {
+			sample, err := s.Sample(t.Context())
+			if err != nil {
+				t.Fatalf("Sample: %v", err)
+			}
+			if _, err := json.Marshal(sample); err != nil {
+				t.Fatalf("sample %+v doesn't encode to JSON: %v", sample, err)
+			}
+		})
+	}
+}
+
+func TestProcessCPUPercent(t *testing.T) {
+	s := Process(int32(os.Getpid()), time.Second) //nolint:gosec // pids fit in int32
+	sample, err := s.Sample(t.Context())
+	if err != nil {
+		t.Fatalf("Sample: %v", err)
+	}
+	if got := sample.(ProcessInfo).CPUPercent; got != 0 {
+		t.Fatalf("first CPUPercent = %v, want 0 without a previous sample", got)
+	}
+
+	// Keep a core busy, the next sample measures it.
+	for start := time.Now(); time.Since(start) < 100*time.Millisecond; {
+	}
+	sample, err = s.Sample(t.Context())
+	if err != nil {
+		t.Fatalf("Sample: %v", err)
+	}
+	if got := sample.(ProcessInfo).CPUPercent; got <= 0 {
+		t.Fatalf("CPUPercent = %v after a busy loop, want more than 0", got)
+	}
+}
@@ -0,0 +1,155 @@
+package sse
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventWriteTo(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{name: "data", event: Event{Data: "hello"}, want: "data: hello\n\n"},
+		{
+			name:  "all fields",
+			event: Event{ID: "7", Type: "mem", Data: "x", Retry: 1500 * time.Millisecond},
+			want:  "id: 7\nevent: mem\nretry: 1500\ndata: x\n\n",
+		},
+		{name: "multi-line", event: Event{Data: "a\nb\r\nc\rd"}, want: "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{name: "trailing newline", event: Event{Data: "a\n"}, want: "data: a\ndata: \n\n"},
+		{name: "leading space", event: Event{Data: " a"}, want: "data:  a\n\n"},
+		{name: "retry only", event: Event{Retry: time.Second}, want: "retry: 1000\n\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			n, err := tt.event.WriteTo(&b)
+			if err != nil {
+				t.Fatalf("WriteTo: %v", err)
+			}
+			if b.String() != tt.want || n != int64(len(tt.want)) {
+				t.Fatalf("WriteTo = %q (%d bytes), want %q", b.String(), n, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventWriteToInvalid(t *testing.T) {
+	for _, e := range []Event{
+		{ID: "1\n2", Data: "x"},
+		{ID: "1\x00", Data: "x"},
+		{Type: "a\rb", Data: "x"},
+	} {
+		var b strings.Builder
+		if _, err := e.WriteTo(&b); err == nil || b.Len() > 0 {
+			t.Errorf("WriteTo(%+v) = %q, %v, want an error and nothing written", e, b.String(), err)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := NewWriter(rec)
+	if err := w.Send(Event{Type: "cpu", Data: "1"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if !rec.Flushed {
+		t.Fatal("Send didn't flush")
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+	if got, want := rec.Body.String(), "event: cpu\ndata: 1\n\n"; got != want {
+		t.Fatalf("body = %q, want %q", got, want)
+	}
+}
+
+// deadlineRecorder records the write deadlines set through an
+// http.ResponseController.
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadlines []time.Time
+}
+
+func (r *deadlineRecorder) SetWriteDeadline(t time.Time) error {
+	r.deadlines = append(r.deadlines, t)
+	return nil
+}
+
+func TestWriterCommentAndWriteTimeout(t *testing.T) {
+	rec := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	w := NewWriter(rec)
+	w.WriteTimeout = time.Minute
+	start := time.Now()
+	if err := w.Comment("heartbeat\nsecond line"); err != nil {
+		t.Fatalf("Comment: %v", err)
+	}
+	if err := w.Send(Event{Data: "1"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got, want := rec.Body.String(), ": heartbeat\n: second line\ndata: 1\n\n"; got != want {
+		t.Fatalf("body = %q, want %q", got, want)
+	}
+	if len(rec.deadlines) != 2 {
+		t.Fatalf("deadlines set = %d, want one per write", len(rec.deadlines))
+	}
+	for _, d := range rec.deadlines {
+		if d.Before(start.Add(time.Minute)) {
+			t.Fatalf("deadline = %v, want a minute after %v", d, start)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	s := NewStream(3)
+	for i := range 5 {
+		e := s.Publish(Event{Data: strings.Repeat("x", i)})
+		if want := string(rune('1' + i)); e.ID != want {
+			t.Fatalf("ID = %q, want %q", e.ID, want)
+		}
+	}
+
+	ids := func(events []Event) []string {
+		var ids []string
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+	for _, tt := range []struct {
+		lastEventID string
+		want        []string
+		wantOK      bool
+	}{
+		{lastEventID: "", want: nil, wantOK: true},
+		{lastEventID: "5", want: nil, wantOK: true},
+		{lastEventID: "3", want: []string{"4", "5"}, wantOK: true},
+		{lastEventID: "2", want: []string{"3", "4", "5"}, wantOK: true},
+		// Event 2 is no longer buffered.
+		{lastEventID: "1", want: []string{"3", "4", "5"}, wantOK: false},
+		// IDs from before a restart or another stream.
+		{lastEventID: "9", want: []string{"3", "4", "5"}, wantOK: false},
+		{lastEventID: "abc", want: []string{"3", "4", "5"}, wantOK: false},
+	} {
+		events, ok := s.Since(tt.lastEventID)
+		if got := ids(events); !reflect.DeepEqual(got, tt.want) || ok != tt.wantOK {
+			t.Errorf("Since(%q) = %v, %t, want %v, %t", tt.lastEventID, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestStreamWithoutReplay(t *testing.T) {
+	s := NewStream(0)
+	s.Publish(Event{Data: "a"})
+	s.Publish(Event{Data: "b"})
+	if events, ok := s.Since("2"); len(events) != 0 || !ok {
+		t.Fatalf("Since(latest) = %v, %t, want nothing missed", events, ok)
+	}
+	if events, ok := s.Since("1"); len(events) != 0 || ok {
+		t.Fatalf("Since(older) = %v, %t, want lost events", events, ok)
+	}
+}
@@ -0,0 +1,67 @@
+package sse
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Stream assigns increasing IDs to the events of a source and keeps the
+// last ones, so clients that reconnect can be sent what they missed. It is
+// safe for concurrent use.
+type Stream struct {
+	mu     sync.Mutex
+	lastID uint64
+	// events are the last published events, oldest first.
+	events []Event
+	size   int
+}
+
+// NewStream returns a stream that keeps up to size events for replay.
+func NewStream(size int) *Stream {
+	return &Stream{size: size}
+}
+
+// Publish sets the ID of e to the next one in the stream and records it.
+// The event is returned with its ID, ready to be sent.
+func (s *Stream) Publish(e Event) Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	e.ID = strconv.FormatUint(s.lastID, 10)
+	if s.size <= 0 {
+		return e
+	}
+	if len(s.events) == s.size {
+		copy(s.events, s.events[1:])
+		s.events = s.events[:len(s.events)-1]
+	}
+	s.events = append(s.events, e)
+	return e
+}
+
+// Since returns the recorded events published after the one with ID
+// lastEventID, the value of the Last-Event-ID header of a reconnecting
+// client. ok is false if events were lost, because they no longer fit in
+// the buffer or lastEventID isn't from this stream, e.g. after a restart of
+// the server. The events that are still recorded are returned then.
+// A client connecting for the first time sends no ID and gets none.
+func (s *Stream) Since(lastEventID string) (events []Event, ok bool) {
+	if lastEventID == "" {
+		return nil, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil || id > s.lastID {
+		return append([]Event(nil), s.events...), false
+	}
+	// Events are recorded with consecutive IDs, the first one after id is
+	// at a known offset.
+	first := s.lastID - uint64(len(s.events)) + 1
+	if id+1 < first {
+		return append([]Event(nil), s.events...), false
+	}
+	return append([]Event(nil), s.events[id+1-first:]...), true
+}
@@ -0,0 +1,64 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Writer sends events on an HTTP response.
+type Writer struct {
+	// WriteTimeout, if set, is how long each write may take. The deadline
+	// is extended before every write, so a stream can outlast the
+	// server's WriteTimeout while a client that stops reading is still
+	// cut off.
+	WriteTimeout time.Duration
+
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// NewWriter sets the headers of an event stream on w. They are sent with
+// the first event.
+func NewWriter(w http.ResponseWriter) *Writer {
+	w.Header().Set("Content-Type", "text/event-stream")
+	// Proxies and browsers must not cache the stream.
+	w.Header().Set("Cache-Control", "no-cache")
+	return &Writer{w: w, rc: http.NewResponseController(w)}
+}
+
+// Send writes e and flushes it to the client.
+func (w *Writer) Send(e Event) error {
+	return w.write(func() error {
+		_, err := e.WriteTo(w.w)
+		return err
+	})
+}
+
+// Comment writes a comment, which clients ignore. Sent as a heartbeat, it
+// keeps proxies from closing a stream that is idle.
+func (w *Writer) Comment(text string) error {
+	return w.write(func() error {
+		var b strings.Builder
+		for line := range lines(text) {
+			b.WriteString(": " + line + "\n")
+		}
+		_, err := io.WriteString(w.w, b.String())
+		return err
+	})
+}
+
+func (w *Writer) write(write func() error) error {
+	if w.WriteTimeout > 0 {
+		err := w.rc.SetWriteDeadline(time.Now().Add(w.WriteTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	return w.rc.Flush()
+}